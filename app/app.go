@@ -8,10 +8,15 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
@@ -30,25 +35,57 @@ const (
 
 // CLIApplication represents the download manager instance.
 type CLIApplication struct {
-	In        io.Reader
-	Out       io.Writer
-	URLS      []string
-	Client    *http.Client
-	chunkSize int
-	outputDir string
-	verbose   bool
-	limiter   *rateLimiter
+	In          io.Reader
+	Out         io.Writer
+	URLS        []string
+	Client      *http.Client
+	chunkSize   int
+	jobs        int
+	order       orderPolicy
+	outputDir   string
+	hlsVariant  string
+	verbose     bool
+	limiter     *rateLimiter
+	hostLimits  *hostLimiters
+	schedule    *limitSchedule
+	controlPath string
+	stateDir    string
+	rpcListen   string
+	rpcSecret   string
+	watchDir    string
+	watchEvery  time.Duration
+	keyboard    bool
+	display     atomic.Pointer[progressDisplay] // the keys pause its downloads
+	fileRate    int64
+	burst       int64
+	conns       *connLimiter
+	pacer       *hostPacer
+	dialer      *dialer
+	s3          *s3Config
+	crawlOpts   *crawlOptions
+	feedOpts    *feedOptions
+	nameTmpl    string
+	byteRange   *byteRange
+	toStdout    bool
+	streamBuf   int64
+	globOff     bool
+	hints       map[string]urlHint
+	hintsMu     sync.Mutex
 }
 
 // NewCLIApplication creates and configures a new CLI app instance.
 func NewCLIApplication() *CLIApplication {
+	d := newDialer()
+
 	transport := http.DefaultTransport.(*http.Transport).Clone()
 	transport.DisableCompression = true
+	transport.DialContext = d.DialContext
 
 	return &CLIApplication{
 		In:     os.Stdin,
 		Out:    os.Stdout,
 		Client: &http.Client{Transport: transport},
+		dialer: d,
 	}
 }
 
@@ -59,15 +96,99 @@ func (c *CLIApplication) parseFlags() error {
 		flagVersion   bool
 		flagVerbose   bool
 		flagChunkSize int
+		flagJobs      int
+		flagOrder     string
 		flagLimit     string
+		flagFileLimit string
+		flagBurst     string
+		flagHostLimit string
+		flagSchedule  string
+		flagControl   string
+		flagStateDir  string
+		flagRPCListen string
+		flagRPCSecret string
+		flagWatch     string
+		flagWatchIntv time.Duration
 		flagOutput    string
+		flagVariant   string
+		flagMaxConns  int
+		flagWait      time.Duration
+		flagRandWait  bool
+		flagHostConns int
+		flagBind      string
+		flagInterface string
+		flagResolve   stringListFlag
+		flagIPv4      bool
+		flagIPv6      bool
+		flagDoH       string
+		flagFallback  bool
+		flagEndpoint  string
+		flagRegion    string
+		flagProfile   string
+		flagRecursive bool
+		flagDepth     int
+		flagAccept    string
+		flagReject    string
+		flagSpanHosts bool
+		flagParent    bool
+		flagNoRobots  bool
+		flagFeeds     stringListFlag
+		flagNewest    int
+		flagFeedNew   bool
+		flagName      string
+		flagGlobOff   bool
+		flagRange     string
+		flagStdout    string
+		flagStreamBuf string
 	)
 
 	flag.BoolVar(&flagVersion, "version", false, "display version information ("+Version+")")
 	flag.BoolVar(&flagVerbose, "verbose", false, "verbose output / debug logging")
 	flag.IntVar(&flagChunkSize, "chunks", defaultChunkSize, "chunk size for parallel download")
+	flag.IntVar(&flagJobs, "jobs", 0, "maximum files downloading at once, the rest wait (0=all)")
+	flag.StringVar(&flagOrder, "order", "input", "which queued file starts next: input, smallest, largest or priority, needs -jobs N")
 	flag.StringVar(&flagLimit, "limit", "0", "bandwidth limit (e.g. 5M, 500K, 0=unlimited)")
+	flag.StringVar(&flagBurst, "limit-burst", "0", "bytes a limit passes at once after idling (0=one second)")
+	flag.StringVar(&flagFileLimit, "limit-per-file", "0", "bandwidth limit of each download (0=unlimited)")
+	flag.StringVar(&flagSchedule, "limit-schedule", "", "time of day limits, e.g. 08:00-18:00=1M,18:00-08:00=0")
+	flag.StringVar(&flagControl, "control", "", "unix socket to change the limit at runtime")
+	flag.StringVar(&flagStateDir, "state-dir", defaultStateDir(), "where leech daemon keeps its queue and socket")
+	flag.StringVar(&flagRPCListen, "rpc-listen", "", "daemon: serve aria2 compatible JSON-RPC here, e.g. localhost:6800")
+	flag.StringVar(&flagRPCSecret, "rpc-secret", "", "daemon: token the JSON-RPC clients must send")
+	flag.StringVar(&flagWatch, "watch", "", "download the url lists dropped into this directory")
+	flag.DurationVar(&flagWatchIntv, "watch-interval", defaultWatchInterval, "how often -watch looks for new lists")
+	flag.StringVar(&flagHostLimit, "limit-per-host", "0", "bandwidth limit of each host (0=unlimited)")
 	flag.StringVar(&flagOutput, "output", ".", "output directory")
+	flag.StringVar(&flagStdout, "O", "", "write the downloads to stdout with -O -")
+	flag.StringVar(&flagStreamBuf, "stream-buffer", "64M", "memory cap of the reorder buffer in -O - mode")
+	flag.DurationVar(&flagWait, "wait", 0, "time between requests to the same host, e.g. 2s")
+	flag.BoolVar(&flagRandWait, "random-wait", false, "vary -wait between 0.5 and 1.5 times")
+	flag.IntVar(&flagMaxConns, "max-connections", 0, "maximum open connections in total (0=unlimited)")
+	flag.IntVar(&flagHostConns, "max-conn-per-host", 0, "maximum open connections per host (0=unlimited)")
+	flag.StringVar(&flagBind, "bind-address", "", "local address(es) for outgoing connections, comma separated")
+	flag.StringVar(&flagInterface, "interface", "", "network interface for outgoing connections")
+	flag.Var(&flagResolve, "resolve", "force host:port to resolve to addr, host:port:addr (repeatable)")
+	flag.BoolVar(&flagIPv4, "4", false, "use IPv4 addresses only")
+	flag.BoolVar(&flagIPv6, "6", false, "use IPv6 addresses only")
+	flag.StringVar(&flagDoH, "doh-url", "", "resolve host names with this DNS-over-HTTPS endpoint")
+	flag.BoolVar(&flagFallback, "doh-fallback", false, "use the system resolver when the DoH server fails, not for names it says do not exist")
+	flag.StringVar(&flagEndpoint, "s3-endpoint", "", "s3 compatible endpoint URL (default: AWS)")
+	flag.StringVar(&flagRegion, "s3-region", "", "s3 region (default: $AWS_REGION or us-east-1)")
+	flag.StringVar(&flagProfile, "s3-profile", "", "profile in the AWS shared credentials file")
+	flag.BoolVar(&flagRecursive, "recursive", false, "crawl HTML pages and download the linked files")
+	flag.IntVar(&flagDepth, "depth", defaultCrawlDepth, "maximum crawl depth in recursive mode")
+	flag.StringVar(&flagAccept, "accept", "", "recursive mode: download only files matching these globs")
+	flag.StringVar(&flagReject, "reject", "", "recursive mode: skip files matching these globs")
+	flag.BoolVar(&flagSpanHosts, "span-hosts", false, "recursive mode: follow links to other hosts")
+	flag.BoolVar(&flagParent, "allow-parent", false, "recursive mode: follow links above the start directory")
+	flag.BoolVar(&flagNoRobots, "ignore-robots", false, "recursive mode: ignore robots.txt")
+	flag.StringVar(&flagRange, "range", "", "download only this byte range: START-END, START- or -N (e.g. -1M)")
+	flag.StringVar(&flagName, "name", "", "output name for url patterns, #1 is the value of the first glob")
+	flag.BoolVar(&flagGlobOff, "globoff", false, "treat [] and {} in urls literally")
+	flag.Var(&flagFeeds, "feed", "download the enclosures of an RSS or Atom feed (repeatable)")
+	flag.IntVar(&flagNewest, "feed-newest", 0, "feed mode: only the newest N enclosures (0=all)")
+	flag.BoolVar(&flagFeedNew, "feed-new", false, "feed mode: skip enclosures already in the output directory")
+	flag.StringVar(&flagVariant, "hls-variant", hlsVariantBest, "hls variant: best, worst, WxH, 720p or max bandwidth")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, cmdUsage, os.Args[0], Version)
@@ -90,14 +211,221 @@ func (c *CLIApplication) parseFlags() error {
 		return fmt.Errorf("chunks must be between 1 and %d", maxChunkSize)
 	}
 
+	if flagJobs < 0 {
+		return errors.New("jobs must not be negative")
+	}
+
+	if flagDepth < 1 {
+		return errors.New("depth must be at least 1")
+	}
+
+	if flagWatchIntv <= 0 {
+		return errors.New("watch-interval must be positive")
+	}
+
+	if flagWait < 0 {
+		return errors.New("wait must not be negative")
+	}
+
+	if flagMaxConns < 0 || flagHostConns < 0 {
+		return errors.New("connection limits must not be negative")
+	}
+
+	localAddrs, err := parseLocalAddrs(flagBind, flagInterface)
+	if err != nil {
+		return err
+	}
+
+	overrides, err := parseResolve(flagResolve)
+	if err != nil {
+		return err
+	}
+
+	network, err := addressFamily(flagIPv4, flagIPv6)
+	if err != nil {
+		return err
+	}
+
 	c.chunkSize = flagChunkSize
+	c.jobs = flagJobs
+
+	if c.order, err = parseOrder(flagOrder); err != nil {
+		return err
+	}
+	if c.order != orderInput && c.jobs <= 0 {
+		// without a queue every download starts at once, there is no order
+		return fmt.Errorf("%w: -order %s needs -jobs N", errInvalidOrder, flagOrder)
+	}
 	c.outputDir = flagOutput
+	c.hlsVariant = flagVariant
 	c.verbose = flagVerbose
+
+	if c.burst, err = parseRate(flagBurst); err != nil {
+		return fmt.Errorf("invalid limit-burst: %w", err)
+	}
+
 	c.limiter = newRateLimiter(rate)
+	c.limiter.setBurst(c.burst)
+
+	if flagSchedule != "" {
+		if c.schedule, err = parseSchedule(flagSchedule, rate); err != nil {
+			return err
+		}
+	}
+
+	if c.fileRate, err = parseRate(flagFileLimit); err != nil {
+		return fmt.Errorf("invalid limit-per-file: %w", err)
+	}
+
+	hostRate, err := parseRate(flagHostLimit)
+	if err != nil {
+		return fmt.Errorf("invalid limit-per-host: %w", err)
+	}
+	c.hostLimits = newHostLimiters(hostRate, c.burst)
+
+	if c.dialer == nil {
+		c.dialer = newDialer()
+	}
+
+	c.dialer.localAddrs = localAddrs
+	c.dialer.overrides = overrides
+	c.dialer.network = network
+
+	if flagDoH != "" {
+		doh, err := newDoHFromFlags(flagDoH, flagFallback, c.dialer)
+		if err != nil {
+			return err
+		}
+		c.dialer.doh = doh
+	}
+
+	if len(flagFeeds) > 0 {
+		if flagNewest < 0 {
+			return errors.New("feed-newest must not be negative")
+		}
+
+		opts := &feedOptions{newest: flagNewest, onlyNew: flagFeedNew}
+		for _, raw := range flagFeeds {
+			url, err := parseValidateURL(raw)
+			if err != nil {
+				return fmt.Errorf("invalid feed: %w", err)
+			}
+			opts.urls = append(opts.urls, url)
+		}
+		c.feedOpts = opts
+	}
+
+	if flagRecursive {
+		c.crawlOpts = &crawlOptions{
+			accept:       splitPatterns(flagAccept),
+			reject:       splitPatterns(flagReject),
+			depth:        flagDepth,
+			spanHosts:    flagSpanHosts,
+			allowParent:  flagParent,
+			ignoreRobots: flagNoRobots,
+		}
+	}
+
+	if flagRange != "" {
+		br, err := parseByteRange(flagRange)
+		if err != nil {
+			return err
+		}
+		c.byteRange = br
+	}
+
+	switch flagStdout {
+	case "":
+	case stdoutName:
+		c.toStdout = true
+	default:
+		return fmt.Errorf("invalid -O %q: only - (stdout) is supported, use -output for a directory", flagStdout)
+	}
+
+	if c.streamBuf, err = parseRate(flagStreamBuf); err != nil || c.streamBuf < streamMinPiece {
+		return fmt.Errorf("invalid stream-buffer %q: want at least 64K", flagStreamBuf)
+	}
+
+	c.controlPath = flagControl
+	c.stateDir = flagStateDir
+	c.rpcListen = flagRPCListen
+	c.rpcSecret = flagRPCSecret
+	c.watchDir = flagWatch
+	c.watchEvery = flagWatchIntv
+	c.pacer = newHostPacer(flagWait, flagRandWait)
+	c.nameTmpl = flagName
+	c.globOff = flagGlobOff
+	c.s3 = newS3ConfigFromEnv()
+	if flagEndpoint != "" {
+		c.s3.endpoint = flagEndpoint
+	}
+	if flagRegion != "" {
+		c.s3.region = flagRegion
+	}
+	if flagProfile != "" {
+		c.s3.profile = flagProfile
+	}
+
+	if flagMaxConns > 0 || flagHostConns > 0 {
+		c.conns = newConnLimiter(flagMaxConns, flagHostConns)
+
+		if c.Client != nil {
+			if t, ok := c.Client.Transport.(*http.Transport); ok {
+				t.MaxConnsPerHost = flagHostConns
+			}
+		}
+	}
 
 	return nil
 }
 
+func parseLocalAddrs(bindAddress, iface string) ([]net.IP, error) {
+	switch {
+	case bindAddress != "" && iface != "":
+		return nil, errors.New("use either -bind-address or -interface")
+	case bindAddress != "":
+		return parseBindAddresses(bindAddress)
+	case iface != "":
+		return interfaceAddresses(iface)
+	default:
+		return nil, nil
+	}
+}
+
+// newDoHFromFlags builds the DoH resolver. Its own connections go through
+// a dialer with the same settings but without DoH, so the DoH server name
+// is resolved by the system resolver or a -resolve override.
+func newDoHFromFlags(url string, fallback bool, d *dialer) (*dohResolver, error) {
+	u, err := neturl.ParseRequestURI(url)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return nil, fmt.Errorf("invalid doh url: %q", url)
+	}
+
+	plain := &dialer{
+		overrides:  d.overrides,
+		network:    d.network,
+		localAddrs: d.localAddrs,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = plain.DialContext
+
+	return newDoHResolver(u.String(), &http.Client{Transport: transport}, fallback), nil
+}
+
+func addressFamily(ipv4, ipv6 bool) (string, error) {
+	switch {
+	case ipv4 && ipv6:
+		return "", errors.New("use either -4 or -6")
+	case ipv4:
+		return "tcp4", nil
+	case ipv6:
+		return "tcp6", nil
+	default:
+		return "", nil
+	}
+}
+
 func (c *CLIApplication) setupLogging() {
 	level := slog.LevelWarn
 	if c.verbose {
@@ -108,6 +436,25 @@ func (c *CLIApplication) setupLogging() {
 }
 
 func (c *CLIApplication) parsePipe(r io.Reader) error {
+	err := scanURLs(r, func(line string) bool {
+		return c.addLine(line, func(url string) bool {
+			c.URLS = append(c.URLS, url)
+
+			return true
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if len(c.URLS) == 0 {
+		return errEmptyPipe
+	}
+	return nil
+}
+
+// scanURLs passes every non-empty line of r to emit until emit returns
+// false. Lines may end in \n, \r\n or a bare \r.
+func scanURLs(r io.Reader, emit func(string) bool) error {
 	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		for _, line := range strings.Split(scanner.Text(), "\r") {
@@ -116,27 +463,20 @@ func (c *CLIApplication) parsePipe(r io.Reader) error {
 				continue
 			}
 
-			url, err := parseValidateURL(line)
-			if err == nil {
-				c.URLS = append(c.URLS, url)
+			if !emit(line) {
+				return nil
 			}
 		}
 	}
 	if err := scanner.Err(); err != nil {
 		return fmt.Errorf("failed to read input: %w", err)
 	}
-	if len(c.URLS) == 0 {
-		return errEmptyPipe
-	}
 	return nil
 }
 
 func (c *CLIApplication) parseArgs(args []string) {
 	for _, arg := range args {
-		url, err := parseValidateURL(arg)
-		if err == nil {
-			c.URLS = append(c.URLS, url)
-		}
+		c.addURL(arg)
 	}
 }
 
@@ -152,114 +492,82 @@ func (c *CLIApplication) Run() error {
 
 	c.setupLogging()
 
-	if isPiped() {
-		if err := c.parsePipe(c.In); err != nil {
-			return err
-		}
+	if args := flag.Args(); len(args) > 0 && daemonCommands[args[0]] {
+		return c.runCommand(args[0], args[1:])
 	}
-	c.parseArgs(flag.Args())
 
-	if len(c.URLS) == 0 {
-		return errEmptyURL
+	if c.rpcListen != "" {
+		return errors.New("-rpc-listen needs daemon mode: leech -rpc-listen ADDR daemon")
 	}
 
-	if err := os.MkdirAll(c.outputDir, permDir); err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
+	if c.watchDir != "" {
+		return c.runWatch(flag.Args())
 	}
 
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
-	defer stop()
-
-	// phase 1: collect resource info for all URLs (HEAD requests)
-	slog.Info("checking resources", "urls", len(c.URLS))
-
-	resChan := make(chan *resource)
+	// without -recursive the piped list is streamed into the pipeline, the
+	// crawler needs its start pages up front
+	streamPipe := isPiped() && c.crawlOpts == nil
 
-	for _, u := range c.URLS {
-		go func(url string) {
-			slog.Debug("fetching resource info", logKeyURL, url)
-			r, err := c.getResourceInformation(ctx, url)
-			if err != nil {
-				slog.Error("resource info failed", logKeyURL, url, logKeyError, err)
-				resChan <- nil
-
-				return
-			}
-			resChan <- r
-		}(u)
+	if isPiped() && !streamPipe {
+		if err := c.parsePipe(c.In); err != nil {
+			return err
+		}
 	}
+	c.parseArgs(flag.Args())
 
-	var resources []*resource
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
-	var totalSize int64
+	if c.schedule != nil {
+		go c.runSchedule(ctx)
+	}
 
-	for range c.URLS {
-		r := <-resChan
-		if r != nil {
-			resources = append(resources, r)
-			if r.length > 0 {
-				totalSize += r.length
-			}
-		}
+	stopControl, err := c.startControl(ctx)
+	if err != nil {
+		return err
 	}
+	defer stopControl()
 
-	if len(resources) == 0 {
-		return errors.New("no valid resources found")
+	if c.crawlOpts != nil {
+		c.URLS = c.crawl(ctx, c.URLS)
 	}
 
-	deduplicateFilenames(resources, c.outputDir)
+	if c.feedOpts != nil {
+		c.URLS = append(c.URLS, c.expandFeeds(ctx)...)
+	}
 
-	// phase 2: show summary and check disk space
-	slog.Info("download summary",
-		"files", len(resources),
-		"total_size", formatBytes(totalSize),
-		"output", c.outputDir,
-	)
+	if len(c.URLS) == 0 && !streamPipe {
+		return errEmptyURL
+	}
 
-	if totalSize > 0 {
-		if err := checkDiskSpace(c.outputDir, totalSize); err != nil {
-			return err
+	if !c.toStdout {
+		if err := os.MkdirAll(c.outputDir, permDir); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
 		}
 	}
 
-	// phase 3: start downloads
-	slog.Info("starting downloads", "files", len(resources), "chunks", c.chunkSize)
-
-	pd := newProgressDisplay()
-	done := make(chan downloadResult, len(resources))
-
-	pd.start()
-
-	for _, r := range resources {
-		go c.download(ctx, r, done, pd)
+	// urls are probed and downloaded as they come in: a file starts as soon
+	// as its probe and the probes before it are done
+	var pipe io.Reader
+	if streamPipe {
+		pipe = c.In
 	}
 
-	var completedSize int64
-	var failCount int
+	// a stage that returns early stops the ones feeding it
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	for range resources {
-		result := <-done
-		if !result.ok {
-			failCount++
-		}
-
-		completedSize += result.size
+	queue := newJobQueue(c.jobs, c.order)
+	stats := &pipelineStats{}
+	resources := c.probeURLs(ctx, c.sourceURLs(ctx, pipe, queue, stats), queue, stats)
 
-		remaining := totalSize - completedSize
-		if remaining > 0 {
-			if err := checkDiskSpace(c.outputDir, remaining); err != nil {
-				slog.Error("disk space warning", logKeyError, err)
-			}
+	if c.toStdout {
+		if err := c.runStdout(ctx, resources); err != nil {
+			return err
 		}
-	}
-
-	pd.finish()
 
-	if failCount > 0 {
-		return fmt.Errorf("%d download(s) failed", failCount)
+		return stats.check()
 	}
 
-	slog.Info("all downloads complete", "count", len(resources))
-
-	return nil
+	return c.runDownloads(ctx, resources, queue, stats)
 }
@@ -11,6 +11,7 @@ import (
 	"strings"
 	"testing"
 	"testing/iotest"
+	"time"
 )
 
 func TestParsePipe(t *testing.T) {
@@ -61,7 +62,7 @@ func TestParsePipeEmpty(t *testing.T) {
 func TestParsePipeAllInvalid(t *testing.T) {
 	app := &CLIApplication{}
 
-	err := app.parsePipe(strings.NewReader("ftp://bad\nno-scheme\n"))
+	err := app.parsePipe(strings.NewReader("gopher://bad\nno-scheme\n"))
 	if err == nil {
 		t.Error("expected error for pipe with only invalid URLs")
 	}
@@ -132,7 +133,7 @@ func TestParseArgsMixed(t *testing.T) {
 	args := []string{
 		"https://example.com/valid.zip",
 		"not-a-url",
-		"ftp://invalid-scheme.com/file",
+		"gopher://invalid-scheme.com/file",
 		"http://example.com/also-valid.tar.gz",
 	}
 	app.parseArgs(args)
@@ -270,6 +271,123 @@ func TestParseFlags(t *testing.T) {
 			args:    []string{"leech", "-chunks", "100"},
 			wantErr: true,
 		},
+		{
+			name: "connection limits",
+			args: []string{"leech", "-max-connections", "16", "-max-conn-per-host", "4"},
+			checkFunc: func(c *CLIApplication) error {
+				if c.conns == nil || cap(c.conns.global) != 16 || c.conns.perHost != 4 {
+					return errors.New("connection limiter mismatch")
+				}
+				return nil
+			},
+		},
+		{
+			name: "bind addresses",
+			args: []string{"leech", "-bind-address", "127.0.0.1,127.0.0.2"},
+			checkFunc: func(c *CLIApplication) error {
+				if c.dialer == nil || len(c.dialer.localAddrs) != 2 {
+					return errors.New("bind addresses mismatch")
+				}
+				return nil
+			},
+		},
+		{
+			name:    "bind address and interface",
+			args:    []string{"leech", "-bind-address", "127.0.0.1", "-interface", "lo"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid bind address",
+			args:    []string{"leech", "-bind-address", "localhost"},
+			wantErr: true,
+		},
+		{
+			name: "resolve and family",
+			args: []string{"leech", "-4", "-resolve", "a.example.com:443:127.0.0.1", "-resolve", "b.example.com:80:127.0.0.2"},
+			checkFunc: func(c *CLIApplication) error {
+				if len(c.dialer.overrides) != 2 || c.dialer.network != "tcp4" {
+					return errors.New("dialer settings mismatch")
+				}
+				return nil
+			},
+		},
+		{
+			name:    "both families",
+			args:    []string{"leech", "-4", "-6"},
+			wantErr: true,
+		},
+		{
+			name: "doh",
+			args: []string{"leech", "-doh-url", "https://dns.example/dns-query", "-doh-fallback"},
+			checkFunc: func(c *CLIApplication) error {
+				if c.dialer.doh == nil || !c.dialer.doh.fallback {
+					return errors.New("doh resolver mismatch")
+				}
+				return nil
+			},
+		},
+		{
+			name:    "invalid doh url",
+			args:    []string{"leech", "-doh-url", "dns.example"},
+			wantErr: true,
+		},
+		{
+			name:    "negative connection limit",
+			args:    []string{"leech", "-max-conn-per-host", "-1"},
+			wantErr: true,
+		},
+		{
+			name: "jobs",
+			args: []string{"leech", "-jobs", "3"},
+			checkFunc: func(c *CLIApplication) error {
+				if c.jobs != 3 {
+					return errors.New("jobs mismatch")
+				}
+				return nil
+			},
+		},
+		{
+			name: "order",
+			args: []string{"leech", "-jobs", "2", "-order", "smallest"},
+			checkFunc: func(c *CLIApplication) error {
+				if c.order != orderSmallest {
+					return errors.New("order mismatch")
+				}
+				return nil
+			},
+		},
+		{
+			name:      "invalid order",
+			args:      []string{"leech", "-order", "random"},
+			wantErr:   true,
+			errTarget: errInvalidOrder,
+		},
+		{
+			name:      "order without jobs",
+			args:      []string{"leech", "-order", "priority"},
+			wantErr:   true,
+			errTarget: errInvalidOrder,
+		},
+		{
+			name: "wait",
+			args: []string{"leech", "-wait", "2s", "-random-wait"},
+			checkFunc: func(c *CLIApplication) error {
+				if c.pacer.gap != 2*time.Second || !c.pacer.random {
+					return errors.New("pacer mismatch")
+				}
+				return nil
+			},
+		},
+		{
+			name:    "negative wait",
+			args:    []string{"leech", "-wait", "-1s"},
+			wantErr: true,
+		},
+		{
+			name:    "negative jobs",
+			args:    []string{"leech", "-jobs", "-1"},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -425,6 +543,34 @@ func TestRunMultipleFiles(t *testing.T) {
 	}
 }
 
+func TestRunJobsQueue(t *testing.T) {
+	content := []byte("queued file content")
+	ts := newTestServer(content, false)
+	defer ts.Close()
+
+	dir := t.TempDir()
+
+	flag.CommandLine = flag.NewFlagSet("leech", flag.ContinueOnError)
+
+	oldArgs := os.Args
+	os.Args = []string{"leech", "-output", dir, "-jobs", "1", ts.URL + "/a.bin", ts.URL + "/b.bin", ts.URL + "/c.bin"}
+	defer func() { os.Args = oldArgs }()
+
+	app := NewCLIApplication()
+	app.Out = io.Discard
+	app.Client = ts.Client()
+
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a.bin", "b.bin", "c.bin"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected file %s to exist", name)
+		}
+	}
+}
+
 func TestRunFailedDownload(t *testing.T) {
 	// HEAD returns 200, GET returns 500
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
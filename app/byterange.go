@@ -0,0 +1,114 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	errInvalidRange       = errors.New("invalid range")
+	errRangeNotSatisfied  = errors.New("range not satisfiable")
+	errRangeNotSupported  = errors.New("server does not accept range requests")
+	errRangeNeedsLength   = errors.New("range needs the size of the file, which the server didn't report")
+	errRangeNotApplicable = errors.New("range can't be used with hls streams")
+)
+
+// byteRange is the span given with -range: START-END, START- (to the end
+// of the file) or -N (the last N bytes). Bounds accept the size suffixes
+// of -limit, e.g. 1M-2M.
+type byteRange struct {
+	start  int64
+	end    int64 // inclusive, -1 means the end of the file
+	suffix int64 // length of a -N range, 0 otherwise
+}
+
+func parseByteRange(s string) (*byteRange, error) {
+	first, last, ok := strings.Cut(strings.TrimSpace(s), "-")
+	if !ok || (first == "" && last == "") {
+		return nil, fmt.Errorf("%w: %q, want START-END, START- or -N", errInvalidRange, s)
+	}
+
+	if first == "" {
+		n, err := parseRate(last)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("%w: %q", errInvalidRange, s)
+		}
+
+		return &byteRange{end: -1, suffix: n}, nil
+	}
+
+	start, err := parseRate(first)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", errInvalidRange, s)
+	}
+
+	br := &byteRange{start: start, end: -1}
+
+	if last != "" {
+		end, err := parseRate(last)
+		if err != nil || end < start {
+			return nil, fmt.Errorf("%w: %q", errInvalidRange, s)
+		}
+
+		br.end = end
+	}
+
+	return br, nil
+}
+
+// resolve returns the absolute first and last byte of the range within a
+// file of length bytes. length <= 0 means the size is unknown.
+func (br *byteRange) resolve(length int64) (int64, int64, error) {
+	if length <= 0 {
+		if br.suffix > 0 || br.end < 0 {
+			return 0, 0, errRangeNeedsLength
+		}
+
+		return br.start, br.end, nil
+	}
+
+	if br.suffix > 0 {
+		return max(length-br.suffix, 0), length - 1, nil
+	}
+
+	if br.start >= length {
+		return 0, 0, fmt.Errorf("%w: starts at %d, file has %d bytes", errRangeNotSatisfied, br.start, length)
+	}
+
+	end := br.end
+	if end < 0 || end >= length {
+		end = length - 1
+	}
+
+	return br.start, end, nil
+}
+
+// applyRange restricts r to the -range span and splits the span into
+// chunks, so it is still fetched in parallel.
+func (c *CLIApplication) applyRange(r *resource, info *remoteInfo) error {
+	if r.hls != nil {
+		return errRangeNotApplicable
+	}
+
+	if !info.ranges {
+		return errRangeNotSupported
+	}
+
+	start, end, err := c.byteRange.resolve(info.length)
+	if err != nil {
+		return err
+	}
+
+	r.ranged = true
+	r.offset = start
+	r.length = end - start + 1
+	r.chunks = getChunks(r.length, c.chunkSize)
+
+	for i := range r.chunks {
+		r.chunks[i][0] += start
+		r.chunks[i][1] += start
+	}
+
+	return nil
+}
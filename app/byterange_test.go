@@ -0,0 +1,142 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseByteRange(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    byteRange
+		wantErr bool
+	}{
+		{input: "0-1023", want: byteRange{start: 0, end: 1023}},
+		{input: "1M-2M", want: byteRange{start: mega, end: 2 * mega}},
+		{input: "100-", want: byteRange{start: 100, end: -1}},
+		{input: "-1M", want: byteRange{end: -1, suffix: mega}},
+		{input: "-", wantErr: true},
+		{input: "10", wantErr: true},
+		{input: "20-10", wantErr: true},
+		{input: "-0", wantErr: true},
+		{input: "a-b", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseByteRange(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseByteRange(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+
+			continue
+		}
+
+		if err == nil && *got != tt.want {
+			t.Errorf("parseByteRange(%q) = %+v, want %+v", tt.input, *got, tt.want)
+		}
+	}
+}
+
+func TestByteRangeResolve(t *testing.T) {
+	tests := []struct {
+		br        byteRange
+		length    int64
+		wantStart int64
+		wantEnd   int64
+		wantErr   error
+	}{
+		{byteRange{start: 10, end: 19}, 100, 10, 19, nil},
+		{byteRange{start: 90, end: 200}, 100, 90, 99, nil},
+		{byteRange{start: 50, end: -1}, 100, 50, 99, nil},
+		{byteRange{end: -1, suffix: 30}, 100, 70, 99, nil},
+		{byteRange{end: -1, suffix: 300}, 100, 0, 99, nil},
+		{byteRange{start: 10, end: 19}, 0, 10, 19, nil},
+		{byteRange{start: 100, end: -1}, 100, 0, 0, errRangeNotSatisfied},
+		{byteRange{end: -1, suffix: 30}, 0, 0, 0, errRangeNeedsLength},
+	}
+
+	for _, tt := range tests {
+		start, end, err := tt.br.resolve(tt.length)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%+v.resolve(%d) error = %v, want %v", tt.br, tt.length, err, tt.wantErr)
+
+			continue
+		}
+
+		if start != tt.wantStart || end != tt.wantEnd {
+			t.Errorf("%+v.resolve(%d) = %d-%d, want %d-%d", tt.br, tt.length, start, end, tt.wantStart, tt.wantEnd)
+		}
+	}
+}
+
+func TestDownloadByteRange(t *testing.T) {
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	ts := newTestServer(content, true)
+	defer ts.Close()
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"span", "10-19", "abcdefghij"},
+		{"suffix", "-5", "vwxyz"},
+		{"open", "30-", "uvwxyz"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			br, err := parseByteRange(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dir := t.TempDir()
+			app := &CLIApplication{
+				Client:    ts.Client(),
+				chunkSize: 3,
+				limiter:   newRateLimiter(0),
+				outputDir: dir,
+				byteRange: br,
+			}
+
+			r, err := app.getResourceInformation(context.Background(), ts.URL+"/file.bin")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if r.length != int64(len(tt.want)) || len(r.chunks) != 3 {
+				t.Fatalf("length = %d, chunks = %d", r.length, len(r.chunks))
+			}
+
+			done := make(chan downloadResult, 1)
+			app.download(context.Background(), r, done, newProgressDisplay())
+
+			if result := <-done; !result.ok {
+				t.Fatal("expected range download to succeed")
+			}
+
+			got, err := os.ReadFile(filepath.Join(dir, "file.bin"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != tt.want {
+				t.Errorf("downloaded %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownloadByteRangeUnsupported(t *testing.T) {
+	ts := newTestServer([]byte("no ranges here"), false)
+	defer ts.Close()
+
+	app := &CLIApplication{Client: ts.Client(), chunkSize: 2, byteRange: &byteRange{start: 0, end: 3}}
+
+	if _, err := app.getResourceInformation(context.Background(), ts.URL+"/file.bin"); !errors.Is(err, errRangeNotSupported) {
+		t.Errorf("error = %v, want %v", err, errRangeNotSupported)
+	}
+}
@@ -0,0 +1,204 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	neturl "net/url"
+	"sync"
+)
+
+// connLimiter caps open connections per host and in total. A zero limit
+// means unlimited.
+type connLimiter struct {
+	global  chan struct{}
+	hosts   map[string]chan struct{}
+	perHost int
+	mu      sync.Mutex
+}
+
+func newConnLimiter(total, perHost int) *connLimiter {
+	cl := &connLimiter{
+		hosts:   make(map[string]chan struct{}),
+		perHost: perHost,
+	}
+
+	if total > 0 {
+		cl.global = make(chan struct{}, total)
+	}
+
+	return cl
+}
+
+func (cl *connLimiter) hostSlots(host string) chan struct{} {
+	if cl.perHost <= 0 {
+		return nil
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	slots, ok := cl.hosts[host]
+	if !ok {
+		slots = make(chan struct{}, cl.perHost)
+		cl.hosts[host] = slots
+	}
+
+	return slots
+}
+
+// acquire blocks until a connection to host may be opened. The host slot
+// is taken first so a download waiting for a busy host doesn't hold a
+// global slot others could use.
+func (cl *connLimiter) acquire(ctx context.Context, host string) (func(), error) {
+	hostSlots := cl.hostSlots(host)
+
+	if err := takeSlot(ctx, hostSlots, host); err != nil {
+		return nil, err
+	}
+
+	if err := takeSlot(ctx, cl.global, host); err != nil {
+		releaseSlot(hostSlots)
+
+		return nil, err
+	}
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			releaseSlot(cl.global)
+			releaseSlot(hostSlots)
+		})
+	}, nil
+}
+
+func takeSlot(ctx context.Context, slots chan struct{}, host string) error {
+	if slots == nil {
+		return nil
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	slog.Debug("waiting for connection slot", "host", host)
+
+	select {
+	case slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for connection slot: %w", ctx.Err())
+	}
+}
+
+func releaseSlot(slots chan struct{}) {
+	if slots != nil {
+		<-slots
+	}
+}
+
+type heldConnKey struct{}
+
+// withHeldConn marks ctx as already holding the connection slot for its
+// request, so the limitedFetcher doesn't take another one.
+func withHeldConn(ctx context.Context) context.Context {
+	return context.WithValue(ctx, heldConnKey{}, true)
+}
+
+func holdsConn(ctx context.Context) bool {
+	held, _ := ctx.Value(heldConnKey{}).(bool)
+
+	return held
+}
+
+// acquireConn takes a connection slot for url, if connections are limited.
+func (c *CLIApplication) acquireConn(ctx context.Context, url string) (func(), error) {
+	if c.conns == nil {
+		return func() {}, nil
+	}
+
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", errInvalidURL.Error(), err)
+	}
+
+	return c.conns.acquire(ctx, u.Hostname())
+}
+
+// limitedFetcher holds a connection slot for every request of the wrapped
+// fetcher; for streams the slot is released when the body is closed.
+type limitedFetcher struct {
+	fetcher
+	conns *connLimiter
+}
+
+func (lf *limitedFetcher) acquire(ctx context.Context, url string) (func(), error) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", errInvalidURL.Error(), err)
+	}
+
+	return lf.conns.acquire(ctx, u.Hostname())
+}
+
+func (lf *limitedFetcher) probe(ctx context.Context, url string) (*remoteInfo, error) {
+	if holdsConn(ctx) {
+		return lf.fetcher.probe(ctx, url)
+	}
+
+	release, err := lf.acquire(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return lf.fetcher.probe(ctx, url)
+}
+
+func (lf *limitedFetcher) openRange(ctx context.Context, url string, start, end int64) (io.ReadCloser, error) {
+	release, err := lf.acquire(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := lf.fetcher.openRange(ctx, url, start, end)
+	if err != nil {
+		release()
+
+		return nil, err
+	}
+
+	return &releasingBody{ReadCloser: body, release: release}, nil
+}
+
+func (lf *limitedFetcher) openStream(ctx context.Context, url string, offset int64) (io.ReadCloser, int64, error) {
+	release, err := lf.acquire(ctx, url)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	body, start, err := lf.fetcher.openStream(ctx, url, offset)
+	if err != nil {
+		release()
+
+		return nil, 0, err
+	}
+
+	return &releasingBody{ReadCloser: body, release: release}, start, nil
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+
+	return err
+}
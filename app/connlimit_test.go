@@ -0,0 +1,163 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConnLimiterPerHost(t *testing.T) {
+	cl := newConnLimiter(0, 1)
+
+	release, err := cl.acquire(context.Background(), "a.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// other hosts are not affected
+	releaseB, err := cl.acquire(context.Background(), "b.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseB()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := cl.acquire(ctx, "a.example.com"); err == nil {
+		t.Fatal("expected acquire to wait for the busy host")
+	}
+
+	release()
+	release() // releasing twice must not free a second slot
+
+	if _, err := cl.acquire(context.Background(), "a.example.com"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnLimiterGlobal(t *testing.T) {
+	cl := newConnLimiter(2, 0)
+
+	r1, _ := cl.acquire(context.Background(), "a")
+	_, _ = cl.acquire(context.Background(), "b")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := cl.acquire(ctx, "c"); err == nil {
+		t.Fatal("expected global budget to be exhausted")
+	}
+
+	r1()
+
+	if _, err := cl.acquire(context.Background(), "c"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDownloadRespectsConnectionLimit(t *testing.T) {
+	content := []byte(strings.Repeat("connection limited ", 100))
+
+	var active, peak atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Add(1)
+		defer active.Add(-1)
+
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+
+		time.Sleep(20 * time.Millisecond)
+
+		w.Header().Set("Accept-Ranges", "bytes")
+		http.ServeContent(w, r, "file.bin", time.Time{}, strings.NewReader(string(content)))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	app := &CLIApplication{
+		Client:    ts.Client(),
+		chunkSize: 8,
+		limiter:   newRateLimiter(0),
+		outputDir: dir,
+		conns:     newConnLimiter(0, 2),
+	}
+
+	r, err := app.getResourceInformation(context.Background(), ts.URL+"/file.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var downloaded atomic.Int64
+	outputPath := filepath.Join(dir, "file.bin")
+	if ok := app.downloadChunked(context.Background(), r, outputPath, outputPath+".part", &downloaded); !ok {
+		t.Fatal("expected chunked download to succeed")
+	}
+
+	got, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(content) {
+		t.Errorf("downloaded %d bytes, want %d", len(got), len(content))
+	}
+
+	if p := peak.Load(); p > 2 {
+		t.Errorf("peak concurrent connections = %d, want at most 2", p)
+	}
+
+	if downloaded.Load() != int64(len(content)) {
+		t.Errorf("downloaded counter = %d, want %d", downloaded.Load(), len(content))
+	}
+}
+
+func TestProbeWaitsForConnectionSlot(t *testing.T) {
+	content := []byte("probed while the only slot is busy")
+	ts := newTestServer(content, true)
+	defer ts.Close()
+
+	defer func(timeout time.Duration) { probeTimeout = timeout }(probeTimeout)
+	probeTimeout = 50 * time.Millisecond
+
+	app := &CLIApplication{
+		Client:    ts.Client(),
+		chunkSize: 2,
+		conns:     newConnLimiter(1, 0),
+	}
+
+	// a running download holds the only slot for longer than a probe may take
+	release, err := app.conns.acquire(context.Background(), "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(4*probeTimeout, release)
+
+	r, err := app.getResourceInformation(context.Background(), ts.URL+"/file.bin")
+	if err != nil {
+		t.Fatalf("probe should wait for the slot, not time out: %v", err)
+	}
+	if r.length != int64(len(content)) {
+		t.Errorf("length = %d, want %d", r.length, len(content))
+	}
+
+	// the probe gave its slot back
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	releaseAgain, err := app.conns.acquire(ctx, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseAgain()
+}
@@ -0,0 +1,301 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+)
+
+var errUnknownCommand = errors.New("unknown command")
+
+// limitSteps are the rates the faster/slower controls move between; one
+// step above the last is unlimited.
+var limitSteps = []int64{
+	64 * kilo, 128 * kilo, 256 * kilo, 512 * kilo,
+	mega, 2 * mega, 5 * mega, 10 * mega, 20 * mega, 50 * mega, 100 * mega,
+}
+
+// stepLimit returns the rate one step faster (up) or slower from rate.
+func stepLimit(rate int64, up bool) int64 {
+	if up {
+		if rate == 0 {
+			return 0
+		}
+
+		for _, step := range limitSteps {
+			if step > rate {
+				return step
+			}
+		}
+
+		return 0
+	}
+
+	if rate == 0 {
+		return limitSteps[len(limitSteps)-1]
+	}
+
+	for i := len(limitSteps) - 1; i >= 0; i-- {
+		if limitSteps[i] < rate {
+			return limitSteps[i]
+		}
+	}
+
+	return limitSteps[0]
+}
+
+// changeLimit sets the global rate while downloads are running.
+func (c *CLIApplication) changeLimit(rate int64, source string) {
+	if c.limiter.limit() == rate {
+		return
+	}
+
+	c.limiter.setRate(rate)
+	slog.Info("bandwidth limit changed", "limit", formatLimit(rate), "by", source)
+}
+
+func (c *CLIApplication) stepGlobalLimit(up bool, source string) {
+	c.changeLimit(stepLimit(c.limiter.limit(), up), source)
+}
+
+// limitStatus is the progress display line that shows the current limit
+// and, with -limit-schedule, the next scheduled one.
+func (c *CLIApplication) limitStatus() string {
+	if c.limiter == nil {
+		return ""
+	}
+
+	line := "limit: " + formatLimit(c.limiter.limit())
+	if next := c.scheduleStatus(); next != "" {
+		line += ", scheduled " + next
+	}
+	if c.keyboard {
+		line += "  [+] faster  [-] slower  [0] unlimited"
+	}
+
+	return line
+}
+
+// progressStatus is the text below the progress bars: the queue counts
+// when -jobs is set, the current limit and the transfer keys.
+func (c *CLIApplication) progressStatus(queue *jobQueue) string {
+	var lines []string
+
+	if c.jobs > 0 {
+		lines = append(lines, queue.status())
+	}
+
+	if line := c.limitStatus(); line != "" {
+		lines = append(lines, line)
+	}
+
+	if c.keyboard {
+		lines = append(lines, "[up/down] select  [p] pause/resume  [c] cancel  [P] pause all  [R] resume all")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// startControl lets the global limit be changed while running: by the
+// SIGUSR1 (slower) and SIGUSR2 (faster) signals, by keys when stdin is a
+// terminal, and by commands on the -control socket. The returned function
+// restores the terminal and closes the socket.
+func (c *CLIApplication) startControl(ctx context.Context) (func(), error) {
+	cleanups := []func(){watchLimitSignals(ctx, c)}
+
+	stop := func() {
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+	}
+
+	if c.controlPath != "" {
+		ln, err := listenControl(c.controlPath)
+		if err != nil {
+			stop()
+
+			return nil, err
+		}
+
+		go c.serveControl(ln)
+
+		cleanups = append(cleanups, func() {
+			_ = ln.Close()
+			_ = os.Remove(c.controlPath)
+		})
+	}
+
+	if !isPiped() {
+		if restore, err := enableCbreak(os.Stdin); err == nil {
+			c.keyboard = true
+			go c.readKeys(os.Stdin)
+
+			cleanups = append(cleanups, restore)
+		} else {
+			slog.Debug("keyboard control unavailable", logKeyError, err)
+		}
+	}
+
+	return stop, nil
+}
+
+func listenControl(path string) (net.Listener, error) {
+	// a socket left behind by a crashed run would make Listen fail
+	if conn, err := net.Dial("unix", path); err == nil {
+		_ = conn.Close()
+
+		return nil, fmt.Errorf("control socket %s is in use", path)
+	}
+	_ = os.Remove(path)
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open control socket: %w", err)
+	}
+
+	if err := os.Chmod(path, permFile); err != nil {
+		_ = ln.Close()
+
+		return nil, fmt.Errorf("failed to secure control socket: %w", err)
+	}
+
+	return ln, nil
+}
+
+func (c *CLIApplication) readKeys(in *os.File) {
+	buf := make([]byte, 1)
+
+	// bytes seen of an arrow key sequence, ESC [ A
+	var escape int
+
+	for {
+		if _, err := in.Read(buf); err != nil {
+			return
+		}
+
+		switch {
+		case escape == 1 && buf[0] == '[':
+			escape = 2
+
+			continue
+		case escape == 2:
+			escape = 0
+
+			switch buf[0] {
+			case 'A':
+				c.transferKey('k')
+			case 'B':
+				c.transferKey('j')
+			}
+
+			continue
+		}
+		escape = 0
+
+		switch buf[0] {
+		case '+', '=':
+			c.stepGlobalLimit(true, "keyboard")
+		case '-', '_':
+			c.stepGlobalLimit(false, "keyboard")
+		case '0':
+			c.changeLimit(0, "keyboard")
+		case 0x1b:
+			escape = 1
+		default:
+			c.transferKey(buf[0])
+		}
+	}
+}
+
+// transferKey acts on the downloads of the progress display: move the
+// selection, pause, resume or cancel the selected download, or pause and
+// resume all of them.
+func (c *CLIApplication) transferKey(key byte) {
+	pd := c.display.Load()
+	if pd == nil {
+		return
+	}
+
+	switch key {
+	case 'k':
+		pd.moveSelection(-1)
+	case 'j':
+		pd.moveSelection(1)
+	case 'p', ' ':
+		if control := pd.selectedControl(); control != nil {
+			control.togglePause()
+		}
+	case 'c', 'x':
+		if control := pd.selectedControl(); control != nil {
+			control.stop()
+		}
+	case 'P':
+		for _, control := range pd.controls() {
+			control.pause()
+		}
+	case 'R':
+		for _, control := range pd.controls() {
+			control.resume()
+		}
+	}
+}
+
+// serveControl answers line based commands on the control socket:
+//
+//	limit          show the current limit
+//	limit RATE     set the limit, 0 is unlimited
+//	faster/slower  move one step
+func (c *CLIApplication) serveControl(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer func() { _ = conn.Close() }()
+
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				reply, err := c.controlCommand(scanner.Text())
+				if err != nil {
+					reply = "error: " + err.Error()
+				}
+
+				if _, err := fmt.Fprintln(conn, reply); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func (c *CLIApplication) controlCommand(line string) (string, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("%w: empty line", errUnknownCommand)
+	}
+
+	switch {
+	case fields[0] == "limit" && len(fields) == 1:
+	case fields[0] == "limit" && len(fields) == 2:
+		rate, err := parseRate(fields[1])
+		if err != nil {
+			return "", err
+		}
+		c.changeLimit(rate, "control socket")
+	case fields[0] == "faster" && len(fields) == 1:
+		c.stepGlobalLimit(true, "control socket")
+	case fields[0] == "slower" && len(fields) == 1:
+		c.stepGlobalLimit(false, "control socket")
+	default:
+		return "", fmt.Errorf("%w: %q", errUnknownCommand, line)
+	}
+
+	return "limit " + formatLimit(c.limiter.limit()), nil
+}
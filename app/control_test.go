@@ -0,0 +1,119 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStepLimit(t *testing.T) {
+	tests := []struct {
+		rate int64
+		up   bool
+		want int64
+	}{
+		{mega, true, 2 * mega},
+		{mega, false, 512 * kilo},
+		{3 * mega, true, 5 * mega},
+		{3 * mega, false, 2 * mega},
+		{100 * mega, true, 0},
+		{0, true, 0},
+		{0, false, 100 * mega},
+		{64 * kilo, false, 64 * kilo},
+		{kilo, true, 64 * kilo},
+	}
+
+	for _, tt := range tests {
+		if got := stepLimit(tt.rate, tt.up); got != tt.want {
+			t.Errorf("stepLimit(%d, %v) = %d, want %d", tt.rate, tt.up, got, tt.want)
+		}
+	}
+}
+
+func TestControlCommand(t *testing.T) {
+	app := &CLIApplication{limiter: newRateLimiter(mega)}
+
+	tests := []struct {
+		line    string
+		want    string
+		wantErr bool
+	}{
+		{line: "limit", want: "limit 1.0MB/s"},
+		{line: "faster", want: "limit 2.0MB/s"},
+		{line: "slower", want: "limit 1.0MB/s"},
+		{line: "limit 500K", want: "limit 500.0KB/s"},
+		{line: "limit 0", want: "limit unlimited"},
+		{line: "limit fast", wantErr: true},
+		{line: "reboot", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := app.controlCommand(tt.line)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("controlCommand(%q) error = %v, wantErr %v", tt.line, err, tt.wantErr)
+
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("controlCommand(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+
+	if _, err := app.controlCommand("reboot"); !errors.Is(err, errUnknownCommand) {
+		t.Errorf("error = %v, want %v", err, errUnknownCommand)
+	}
+}
+
+func TestControlSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leech.sock")
+	app := &CLIApplication{limiter: newRateLimiter(0), controlPath: path}
+
+	stop, err := app.startControl(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	if _, err := listenControl(path); err == nil {
+		t.Error("expected a second listener on a live socket to fail")
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	reader := bufio.NewReader(conn)
+
+	for _, step := range []struct{ cmd, want string }{
+		{"limit 2M", "limit 2.0MB/s"},
+		{"bogus", "error: unknown command"},
+	} {
+		if _, err := conn.Write([]byte(step.cmd + "\n")); err != nil {
+			t.Fatal(err)
+		}
+
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(line, step.want) {
+			t.Errorf("reply to %q = %q, want %q", step.cmd, line, step.want)
+		}
+	}
+
+	if got := app.limiter.limit(); got != 2*mega {
+		t.Errorf("limit = %d, want %d", got, 2*mega)
+	}
+
+	if status := app.limitStatus(); !strings.HasPrefix(status, "limit: 2.0MB/s") {
+		t.Errorf("limitStatus = %q", status)
+	}
+}
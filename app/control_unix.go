@@ -0,0 +1,37 @@
+//go:build unix
+
+package app
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchLimitSignals steps the global limit down on SIGUSR1 and up on
+// SIGUSR2. The returned function stops watching.
+func watchLimitSignals(ctx context.Context, c *CLIApplication) func() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				c.stepGlobalLimit(sig == syscall.SIGUSR2, "signal "+sig.String())
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
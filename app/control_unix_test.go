@@ -0,0 +1,30 @@
+//go:build unix
+
+package app
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWatchLimitSignals(t *testing.T) {
+	app := &CLIApplication{limiter: newRateLimiter(mega)}
+
+	stop := watchLimitSignals(context.Background(), app)
+	defer stop()
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for app.limiter.limit() == mega && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if got := app.limiter.limit(); got != 512*kilo {
+		t.Errorf("limit after SIGUSR1 = %d, want %d", got, 512*kilo)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package app
+
+import "context"
+
+// watchLimitSignals is a no-op, Windows has no SIGUSR1/SIGUSR2.
+func watchLimitSignals(context.Context, *CLIApplication) func() {
+	return func() {}
+}
@@ -0,0 +1,312 @@
+package app
+
+import (
+	"context"
+	"html"
+	"log/slog"
+	neturl "net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultCrawlDepth = 5
+	crawlMaxPage      = 16 * mega
+)
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:href|src)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+
+// pageExtensions are path extensions that may be HTML pages. Links with
+// these (or no) extension are probed, everything else is queued as a file.
+var pageExtensions = map[string]bool{
+	"":       true,
+	".html":  true,
+	".htm":   true,
+	".xhtml": true,
+	".php":   true,
+	".asp":   true,
+	".aspx":  true,
+	".jsp":   true,
+	".cgi":   true,
+}
+
+// crawlOptions configures recursive mode.
+type crawlOptions struct {
+	accept       []string
+	reject       []string
+	depth        int
+	spanHosts    bool
+	allowParent  bool
+	ignoreRobots bool
+}
+
+type crawlItem struct {
+	url   *neturl.URL
+	depth int
+}
+
+// crawler walks HTML pages breadth first and collects the file links.
+type crawler struct {
+	app    *CLIApplication
+	opts   *crawlOptions
+	root   *neturl.URL
+	seen   map[string]bool
+	robots map[string]*robotsRules
+	files  []string
+}
+
+// crawl expands every root URL into the files reachable from it. Files
+// get a directory hint that mirrors their path below the root directory.
+func (c *CLIApplication) crawl(ctx context.Context, roots []string) []string {
+	var files []string
+
+	seen := make(map[string]bool)
+	robots := make(map[string]*robotsRules)
+
+	for _, root := range roots {
+		u, err := neturl.Parse(root)
+		if err != nil {
+			continue
+		}
+
+		cr := &crawler{
+			app:    c,
+			opts:   c.crawlOpts,
+			root:   u,
+			seen:   seen,
+			robots: robots,
+		}
+		cr.run(ctx)
+
+		slog.Info("crawl finished", logKeyURL, root, "files", len(cr.files))
+		files = append(files, cr.files...)
+	}
+
+	return files
+}
+
+// visitResult is what visiting a crawl item found.
+type visitResult int
+
+const (
+	visitSkip visitResult = iota // failed or stopped, neither a page nor a file
+	visitFile                    // not HTML, downloaded as a file
+	visitPage                    // an HTML page
+)
+
+func (cr *crawler) run(ctx context.Context) {
+	if !cr.robotsAllowed(ctx, cr.root) {
+		slog.Warn("crawl start disallowed by robots.txt", logKeyURL, cr.root.String())
+
+		return
+	}
+
+	queue := []crawlItem{{url: cr.root}}
+	cr.seen[cr.root.String()] = true
+
+	for len(queue) > 0 && ctx.Err() == nil {
+		item := queue[0]
+		queue = queue[1:]
+
+		links, result := cr.visit(ctx, item)
+
+		switch result {
+		case visitFile:
+			cr.addFile(item.url)
+
+			continue
+		case visitSkip:
+			continue
+		}
+
+		for _, link := range links {
+			key := link.String()
+			if cr.seen[key] || !cr.inScope(link) || !cr.robotsAllowed(ctx, link) {
+				continue
+			}
+			cr.seen[key] = true
+
+			if mayBePage(link) {
+				queue = append(queue, crawlItem{url: link, depth: item.depth + 1})
+			} else {
+				cr.addFile(link)
+			}
+		}
+	}
+}
+
+// visit probes item and, if it is an HTML page above the depth limit,
+// fetches it and returns its links. A page at the depth limit is not
+// fetched, its links would not be followed.
+func (cr *crawler) visit(ctx context.Context, item crawlItem) ([]*neturl.URL, visitResult) {
+	target := item.url.String()
+
+	f, err := cr.app.fetcherFor(target)
+	if err != nil {
+		slog.Warn("crawl probe failed", logKeyURL, target, logKeyError, err)
+
+		return nil, visitSkip
+	}
+
+	if err := cr.app.pacer.wait(ctx, target); err != nil {
+		return nil, visitSkip
+	}
+
+	info, err := f.probe(ctx, target)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Warn("crawl probe failed", logKeyURL, target, logKeyError, err)
+		}
+
+		return nil, visitSkip
+	}
+
+	if !strings.Contains(strings.ToLower(info.contentType), "html") {
+		return nil, visitFile
+	}
+
+	if item.depth >= cr.opts.depth {
+		return nil, visitPage
+	}
+
+	if err := cr.app.pacer.wait(ctx, target); err != nil {
+		return nil, visitSkip
+	}
+
+	data, err := cr.app.fetchBytes(ctx, target, crawlMaxPage)
+	if err != nil {
+		slog.Warn("crawl fetch failed", logKeyURL, target, logKeyError, err)
+
+		return nil, visitSkip
+	}
+
+	links := extractLinks(string(data), item.url)
+	slog.Debug("crawled page", logKeyURL, target, "depth", item.depth, "links", len(links))
+
+	return links, visitPage
+}
+
+// extractLinks returns the absolute href and src links of an HTML page,
+// without fragments and without links back to the page itself (such as
+// autoindex sort links).
+func extractLinks(page string, base *neturl.URL) []*neturl.URL {
+	var links []*neturl.URL
+
+	for _, m := range linkPattern.FindAllStringSubmatch(page, -1) {
+		ref := strings.TrimSpace(html.UnescapeString(m[1] + m[2] + m[3]))
+		if ref == "" || strings.HasPrefix(ref, "#") {
+			continue
+		}
+
+		u, err := base.Parse(ref)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+
+		u.Fragment = ""
+		if u.Path == base.Path && u.Host == base.Host {
+			continue
+		}
+
+		links = append(links, u)
+	}
+
+	return links
+}
+
+func mayBePage(u *neturl.URL) bool {
+	if strings.HasSuffix(u.Path, "/") {
+		return true
+	}
+
+	return pageExtensions[strings.ToLower(path.Ext(u.Path))]
+}
+
+// rootDir is the directory the crawl is restricted to.
+func (cr *crawler) rootDir() string {
+	if strings.HasSuffix(cr.root.Path, "/") {
+		return cr.root.Path
+	}
+
+	return path.Dir(cr.root.Path) + "/"
+}
+
+func (cr *crawler) inScope(u *neturl.URL) bool {
+	if !cr.opts.spanHosts && u.Host != cr.root.Host {
+		return false
+	}
+
+	if !cr.opts.allowParent && u.Host == cr.root.Host && !strings.HasPrefix(u.Path, cr.rootDir()) {
+		return false
+	}
+
+	return true
+}
+
+func (cr *crawler) robotsAllowed(ctx context.Context, u *neturl.URL) bool {
+	if cr.opts.ignoreRobots {
+		return true
+	}
+
+	if cr.robotsFor(ctx, u).allowed(u.EscapedPath()) {
+		return true
+	}
+
+	slog.Debug("disallowed by robots.txt", logKeyURL, u.String())
+
+	return false
+}
+
+func (cr *crawler) addFile(u *neturl.URL) {
+	name := path.Base(u.Path)
+	if !matchesPatterns(name, cr.opts.accept, true) || matchesPatterns(name, cr.opts.reject, false) {
+		return
+	}
+
+	url := u.String()
+	cr.files = append(cr.files, url)
+	cr.app.setHint(url, urlHint{dir: cr.localDir(u)})
+}
+
+// localDir mirrors the remote directory of u below the crawl root. Files
+// outside the root directory or on other hosts go below host/path.
+func (cr *crawler) localDir(u *neturl.URL) string {
+	dir := path.Dir(path.Clean("/" + u.Path))
+
+	root := cr.rootDir()
+	if u.Host == cr.root.Host && strings.HasPrefix(dir+"/", root) {
+		return strings.Trim(strings.TrimPrefix(dir+"/", root), "/")
+	}
+
+	return path.Join(u.Hostname(), dir)
+}
+
+// matchesPatterns reports whether name matches one of the glob patterns.
+// An empty pattern list yields empty.
+func matchesPatterns(name string, patterns []string, empty bool) bool {
+	if len(patterns) == 0 {
+		return empty
+	}
+
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, name); err == nil && ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitPatterns splits a comma separated glob list.
+func splitPatterns(s string) []string {
+	var out []string
+
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+
+	return out
+}
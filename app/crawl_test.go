@@ -0,0 +1,203 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func newTestSite(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	pages := map[string]string{
+		"/pub/": `<a href="?C=N;O=D">Name</a> <a href="/">Parent Directory</a>
+			<a href="a.iso">a.iso</a> <a href='a.iso.sha256'>sum</a> <a href=sub/>sub/</a>
+			<a href="private/">private/</a> <a href="http://other.example.com/x.iso">mirror</a>`,
+		"/pub/sub/":        `<a href="../">up</a> <a href="b.iso">b.iso</a> <a href="deeper/">deeper/</a>`,
+		"/pub/sub/deeper/": `<a href="c.iso">c.iso</a>`,
+		"/pub/private/":    `<a href="d.iso">d.iso</a>`,
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			_, _ = w.Write([]byte("User-agent: *\nDisallow: /pub/private/\n"))
+
+			return
+		}
+
+		if page, ok := pages[r.URL.Path]; ok {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte(page))
+
+			return
+		}
+
+		if strings.HasPrefix(r.URL.Path, "/pub/") {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write([]byte("data"))
+
+			return
+		}
+
+		http.NotFound(w, r)
+	}))
+}
+
+func crawlPaths(t *testing.T, app *CLIApplication, root string) []string {
+	t.Helper()
+
+	files := app.crawl(context.Background(), []string{root})
+
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		u, err := neturl.Parse(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, u.Path)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+func TestCrawl(t *testing.T) {
+	ts := newTestSite(t)
+	defer ts.Close()
+
+	app := &CLIApplication{Client: ts.Client(), crawlOpts: &crawlOptions{depth: defaultCrawlDepth}}
+
+	got := crawlPaths(t, app, ts.URL+"/pub/")
+	want := []string{"/pub/a.iso", "/pub/a.iso.sha256", "/pub/sub/b.iso", "/pub/sub/deeper/c.iso"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("crawl = %v, want %v", got, want)
+	}
+
+	if dir := app.hint(ts.URL + "/pub/sub/deeper/c.iso").dir; dir != "sub/deeper" {
+		t.Errorf("dir hint = %q, want sub/deeper", dir)
+	}
+	if dir := app.hint(ts.URL + "/pub/a.iso").dir; dir != "" {
+		t.Errorf("dir hint = %q, want empty", dir)
+	}
+}
+
+func TestCrawlOptions(t *testing.T) {
+	ts := newTestSite(t)
+	defer ts.Close()
+
+	tests := []struct {
+		name string
+		opts crawlOptions
+		want []string
+	}{
+		{
+			name: "depth",
+			opts: crawlOptions{depth: 2},
+			want: []string{"/pub/a.iso", "/pub/a.iso.sha256", "/pub/sub/b.iso"},
+		},
+		{
+			name: "accept",
+			opts: crawlOptions{depth: defaultCrawlDepth, accept: []string{"*.sha256"}},
+			want: []string{"/pub/a.iso.sha256"},
+		},
+		{
+			name: "reject",
+			opts: crawlOptions{depth: 1, reject: []string{"*.sha256"}},
+			want: []string{"/pub/a.iso"},
+		},
+		{
+			name: "ignore robots",
+			opts: crawlOptions{depth: 2, accept: []string{"d.iso"}, ignoreRobots: true},
+			want: []string{"/pub/private/d.iso"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &CLIApplication{Client: ts.Client(), crawlOpts: &tt.opts}
+
+			if got := crawlPaths(t, app, ts.URL+"/pub/"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("crawl = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCrawlDepthLimitAndRobots(t *testing.T) {
+	ts := newTestSite(t)
+	defer ts.Close()
+
+	var pageGets atomic.Int32
+
+	site := ts.Config.Handler
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/pub/sub/deeper/" {
+			pageGets.Add(1)
+		}
+		site.ServeHTTP(w, r)
+	})
+
+	// a page at the depth limit is probed but not fetched
+	app := &CLIApplication{Client: ts.Client(), crawlOpts: &crawlOptions{depth: 2}}
+	crawlPaths(t, app, ts.URL+"/pub/")
+
+	if n := pageGets.Load(); n != 0 {
+		t.Errorf("the page at the depth limit was fetched %d time(s)", n)
+	}
+
+	// robots.txt applies to the start page too
+	app = &CLIApplication{Client: ts.Client(), crawlOpts: &crawlOptions{depth: defaultCrawlDepth}}
+	if got := crawlPaths(t, app, ts.URL+"/pub/private/"); len(got) != 0 {
+		t.Errorf("crawl of a disallowed start page = %v, want nothing", got)
+	}
+
+	// a stopped crawl does not take the page for a file
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	u, _ := neturl.Parse(ts.URL + "/pub/")
+	cr := &crawler{app: app, opts: app.crawlOpts, root: u, seen: map[string]bool{}, robots: map[string]*robotsRules{}}
+	if _, result := cr.visit(ctx, crawlItem{url: u}); result != visitSkip {
+		t.Errorf("visit after cancel = %d, want visitSkip", result)
+	}
+}
+
+func TestExtractLinks(t *testing.T) {
+	base, _ := neturl.Parse("https://example.com/pub/index.html")
+	page := `<a href="index.html#top">self</a> <a href="#x">frag</a> <a href="mailto:a@b">mail</a>
+		<img src="/img/logo.png"> <a href="file.tar.gz?x=1&amp;y=2">file</a>`
+
+	var got []string
+	for _, u := range extractLinks(page, base) {
+		got = append(got, u.String())
+	}
+
+	want := []string{"https://example.com/img/logo.png", "https://example.com/pub/file.tar.gz?x=1&y=2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("extractLinks = %v, want %v", got, want)
+	}
+}
+
+func TestDeduplicateFilenamesPerDirectory(t *testing.T) {
+	resources := []*resource{
+		{filename: "file.iso"},
+		{filename: "file.iso", dir: "sub"},
+		{filename: "file.iso", dir: "sub"},
+	}
+
+	deduplicateFilenames(resources, "")
+
+	got := []string{resources[0].path(), resources[1].path(), resources[2].path()}
+	want := []string{"file.iso", "sub/file.iso", "sub/file_1.iso"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("paths = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,654 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	errUnknownID   = errors.New("no such download")
+	errDaemonReply = errors.New("daemon refused the command")
+)
+
+const (
+	daemonQueueFile  = "queue.json"
+	daemonSocketFile = "leech.sock"
+)
+
+// statuses of a daemon queue entry
+const (
+	statusQueued  = "queued"
+	statusActive  = "active"
+	statusPaused  = "paused"
+	statusDone    = "done"
+	statusFailed  = "failed"
+	statusRemoved = "removed"
+)
+
+// daemonCommands are the subcommands, given as the first argument.
+var daemonCommands = map[string]bool{
+	"daemon": true,
+	"add":    true,
+	"list":   true,
+	"pause":  true,
+	"resume": true,
+	"remove": true,
+}
+
+// defaultStateDir is where the daemon keeps its queue and socket:
+// $XDG_STATE_HOME/leech or ~/.local/state/leech.
+func defaultStateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "leech")
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".leech"
+	}
+
+	return filepath.Join(home, ".local", "state", "leech")
+}
+
+// daemonEntry is a download in the daemon queue. The exported fields are
+// saved to queue.json; the name is fixed on the first probe so a restart
+// continues the same part file.
+type daemonEntry struct {
+	Added    time.Time `json:"added"`
+	URL      string    `json:"url"`
+	Dir      string    `json:"dir,omitempty"`
+	Filename string    `json:"filename,omitempty"`
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Length   int64     `json:"length,omitempty"`
+	ID       int       `json:"id"`
+
+	cancel     context.CancelFunc
+	downloaded atomic.Int64
+	speed      atomic.Int64
+	sampled    int64
+}
+
+// daemon runs queued downloads with the same engine as a normal run and
+// takes commands on a unix socket.
+type daemon struct {
+	app     *CLIApplication
+	names   *fileNames
+	wake    chan struct{}
+	path    string
+	entries []*daemonEntry
+	running sync.WaitGroup
+	nextID  int
+	active  int
+	mu      sync.Mutex
+}
+
+func newDaemon(c *CLIApplication) *daemon {
+	return &daemon{
+		app:    c,
+		names:  newFileNames(c.outputDir),
+		wake:   make(chan struct{}, 1),
+		path:   filepath.Join(c.stateDir, daemonQueueFile),
+		nextID: 1,
+	}
+}
+
+// load reads the saved queue. Downloads that were running when the daemon
+// stopped are queued again and continue from their part files.
+func (d *daemon) load() error {
+	data, err := os.ReadFile(d.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read queue: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &d.entries); err != nil {
+		return fmt.Errorf("failed to parse queue %s: %w", d.path, err)
+	}
+
+	for _, e := range d.entries {
+		if e.Status == statusActive {
+			e.Status = statusQueued
+		}
+
+		if e.Filename != "" {
+			d.names.reserve(e.Dir, e.Filename)
+		}
+
+		d.nextID = max(d.nextID, e.ID+1)
+	}
+
+	return nil
+}
+
+// save writes the queue. Callers hold mu.
+func (d *daemon) save() {
+	data, err := json.MarshalIndent(d.entries, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(d.path, data)
+	}
+
+	if err != nil {
+		slog.Error("failed to save queue", "path", d.path, logKeyError, err)
+	}
+}
+
+func (d *daemon) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// schedule starts queued downloads, oldest first and at most -jobs at a
+// time, until ctx is done.
+func (d *daemon) schedule(ctx context.Context) {
+	for {
+		d.mu.Lock()
+
+		started := false
+
+		for _, e := range d.entries {
+			if d.app.jobs > 0 && d.active >= d.app.jobs {
+				break
+			}
+
+			if e.Status != statusQueued {
+				continue
+			}
+
+			entryCtx, cancel := context.WithCancel(ctx)
+			e.Status = statusActive
+			e.Error = ""
+			e.cancel = cancel
+			d.active++
+
+			d.running.Go(func() { d.run(entryCtx, e) })
+			started = true
+		}
+
+		// every other change saved the queue already
+		if started {
+			d.save()
+		}
+		d.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		}
+	}
+}
+
+// measure updates the speed of the running downloads once a second, until
+// ctx is done.
+func (d *daemon) measure(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		d.mu.Lock()
+		for _, e := range d.entries {
+			n := e.downloaded.Load()
+
+			if e.Status == statusActive {
+				e.speed.Store(max(n-e.sampled, 0))
+			} else {
+				e.speed.Store(0)
+			}
+
+			e.sampled = n
+		}
+		d.mu.Unlock()
+	}
+}
+
+func (d *daemon) run(ctx context.Context, e *daemonEntry) {
+	if e.Filename != "" {
+		d.app.setHint(e.URL, urlHint{dir: e.Dir, filename: e.Filename})
+	}
+
+	r, err := d.app.getResourceInformation(ctx, e.URL)
+	d.app.dropHint(e.URL)
+
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("resource info failed", logKeyURL, e.URL, logKeyError, err)
+		}
+		d.finish(ctx, e, err)
+
+		return
+	}
+
+	d.mu.Lock()
+	if e.Filename == "" {
+		d.names.claim(r)
+		e.Dir, e.Filename = r.dir, r.filename
+	}
+	e.Length = r.length
+	d.save()
+	d.mu.Unlock()
+
+	err = nil
+	if !d.app.fetchResource(ctx, r, &e.downloaded) {
+		err = errors.New("download failed, see the daemon log")
+	}
+
+	d.finish(ctx, e, err)
+}
+
+// finish records the outcome of a download that stopped running. A
+// download that completed is done even if a pause or the shutdown came in
+// just after. Otherwise entries paused or removed meanwhile keep that
+// status, and downloads cut short by the daemon shutting down are queued
+// again.
+func (d *daemon) finish(ctx context.Context, e *daemonEntry, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stopped := ctx.Err() != nil
+	e.cancel()
+	d.active--
+
+	switch {
+	case e.Status == statusRemoved:
+		// removed while running, its part file is closed only now
+		d.removePart(e)
+	case err == nil:
+		e.Status = statusDone
+	case e.Status != statusActive:
+	case stopped:
+		e.Status = statusQueued
+	default:
+		e.Status = statusFailed
+		e.Error = err.Error()
+	}
+
+	d.save()
+	d.notify()
+}
+
+func (d *daemon) find(arg string) (*daemonEntry, error) {
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", errUnknownID, arg)
+	}
+
+	for _, e := range d.entries {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %d", errUnknownID, id)
+}
+
+// command runs one line from the socket and returns the reply.
+func (d *daemon) command(line string) (string, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("%w: empty line", errUnknownCommand)
+	}
+
+	switch name, args := fields[0], fields[1:]; {
+	case name == "add" && len(args) > 0:
+		return d.add(args)
+	case name == "list" && len(args) == 0:
+		return d.list(), nil
+	case (name == "pause" || name == "resume" || name == "remove") && len(args) == 1:
+		return d.change(name, args[0])
+	}
+
+	// the limit commands of -control work here too
+	return d.app.controlCommand(line)
+}
+
+func (d *daemon) add(args []string) (string, error) {
+	var reply []string
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, arg := range args {
+		d.app.expandURL(arg, func(url string) bool {
+			e := d.enqueue(url)
+			reply = append(reply, fmt.Sprintf("added %d %s", e.ID, url))
+
+			return true
+		})
+	}
+
+	if len(reply) == 0 {
+		return "", fmt.Errorf("%w: nothing added", errEmptyURL)
+	}
+
+	d.save()
+	d.notify()
+
+	return strings.Join(reply, "\n"), nil
+}
+
+// enqueue appends a queued entry for url. Callers hold mu, save the queue
+// and notify the scheduler.
+func (d *daemon) enqueue(url string) *daemonEntry {
+	e := &daemonEntry{ID: d.nextID, URL: url, Status: statusQueued, Added: time.Now()}
+	d.nextID++
+	d.entries = append(d.entries, e)
+
+	return e
+}
+
+func (d *daemon) list() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var b strings.Builder
+
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ID\tSTATUS\tPROGRESS\tNAME")
+
+	for _, e := range d.entries {
+		name := e.Filename
+		if name == "" {
+			name = e.URL
+		} else if e.Dir != "" {
+			name = e.Dir + "/" + name
+		}
+
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", e.ID, e.Status, d.progress(e), name)
+		if e.Error != "" {
+			_, _ = fmt.Fprintf(w, "\t\t\t  %s\n", e.Error)
+		}
+	}
+
+	_ = w.Flush()
+
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// progress formats how far e got. Callers hold mu.
+func (d *daemon) progress(e *daemonEntry) string {
+	switch e.Status {
+	case statusDone:
+		return formatBytes(max(e.Length, e.downloaded.Load()))
+	case statusActive:
+		if e.Length > 0 {
+			pct := float64(e.downloaded.Load()) / float64(e.Length) * 100
+
+			return fmt.Sprintf("%3.0f%% %s/%s", pct, formatBytes(e.downloaded.Load()), formatBytes(e.Length))
+		}
+
+		return formatBytes(e.downloaded.Load())
+	}
+
+	return "-"
+}
+
+func (d *daemon) change(name, arg string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	e, err := d.find(arg)
+	if err != nil {
+		return "", err
+	}
+
+	if err := d.changeEntry(name, e); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s %d", e.Status, e.ID), nil
+}
+
+// changeEntry pauses, resumes or removes e and saves the queue. Callers
+// hold mu.
+func (d *daemon) changeEntry(name string, e *daemonEntry) error {
+	switch name {
+	case "pause":
+		if e.Status != statusQueued && e.Status != statusActive {
+			return fmt.Errorf("download %d is %s", e.ID, e.Status)
+		}
+
+		if e.Status == statusActive {
+			e.cancel()
+		}
+		e.Status = statusPaused
+	case "resume":
+		if e.Status != statusPaused && e.Status != statusFailed {
+			return fmt.Errorf("download %d is %s", e.ID, e.Status)
+		}
+
+		e.Status = statusQueued
+		e.Error = ""
+		d.notify()
+	case "remove":
+		if e.Status == statusActive {
+			e.cancel()
+		} else if e.Status != statusDone {
+			d.removePart(e)
+		}
+
+		e.Status = statusRemoved
+		d.entries = removeEntry(d.entries, e)
+	}
+
+	d.save()
+
+	return nil
+}
+
+// removePart deletes the part file of e, it is of no use without its queue
+// entry.
+func (d *daemon) removePart(e *daemonEntry) {
+	if e.Filename == "" {
+		return
+	}
+
+	part := filepath.Join(d.app.outputDir, filepath.FromSlash(e.Dir), e.Filename) + ".part"
+	_ = os.Remove(part)
+	_ = os.Remove(part + chunkStateSuffix)
+}
+
+func removeEntry(entries []*daemonEntry, e *daemonEntry) []*daemonEntry {
+	for i := range entries {
+		if entries[i] == e {
+			return append(entries[:i], entries[i+1:]...)
+		}
+	}
+
+	return entries
+}
+
+// serve answers one command per connection and closes it, so
+// `echo list | nc -U leech.sock` works as well as the leech commands.
+func (d *daemon) serve(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer func() { _ = conn.Close() }()
+
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil && line == "" {
+				return
+			}
+
+			reply, err := d.command(line)
+			if err != nil {
+				reply = "error: " + err.Error()
+			}
+
+			_, _ = fmt.Fprintln(conn, reply)
+		}()
+	}
+}
+
+// runCommand runs a daemon subcommand: the daemon itself or a client
+// command sent to it.
+func (c *CLIApplication) runCommand(command string, args []string) error {
+	// a daemon is stopped by its service manager with SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if command != "daemon" {
+		if err := checkClientFlags(); err != nil {
+			return err
+		}
+
+		return c.runClient(ctx, command, args)
+	}
+
+	if len(args) > 0 {
+		return fmt.Errorf("daemon takes no arguments, add urls with `leech add URL`: %q", args)
+	}
+
+	if !c.verbose {
+		// the daemon log is all there is to follow it by
+		handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelInfo})
+		slog.SetDefault(slog.New(handler))
+	}
+
+	if c.schedule != nil {
+		go c.runSchedule(ctx)
+	}
+
+	defer watchLimitSignals(ctx, c)()
+
+	return c.runDaemon(ctx)
+}
+
+// clientFlags are the flags that apply to the commands sent to a running
+// daemon. All others configure the daemon and are given to `leech daemon`.
+var clientFlags = map[string]bool{
+	"state-dir": true,
+	"verbose":   true,
+}
+
+// checkClientFlags refuses flags that the daemon would silently ignore,
+// like -output on `leech add`.
+func checkClientFlags() error {
+	var ignored []string
+
+	flag.Visit(func(f *flag.Flag) {
+		if !clientFlags[f.Name] {
+			ignored = append(ignored, "-"+f.Name)
+		}
+	})
+
+	if len(ignored) > 0 {
+		return fmt.Errorf("%s only apply when starting `leech daemon`, not to its commands", strings.Join(ignored, ", "))
+	}
+
+	return nil
+}
+
+// runDaemon keeps downloading the queue in c.stateDir until interrupted.
+func (c *CLIApplication) runDaemon(ctx context.Context) error {
+	for _, dir := range []string{c.stateDir, c.outputDir} {
+		if err := os.MkdirAll(dir, permDir); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+
+	d := newDaemon(c)
+	if err := d.load(); err != nil {
+		return err
+	}
+
+	socketPath := filepath.Join(c.stateDir, daemonSocketFile)
+
+	ln, err := listenControl(socketPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = ln.Close()
+		_ = os.Remove(socketPath)
+	}()
+
+	go d.serve(ln)
+	go d.measure(ctx)
+
+	if c.rpcListen != "" {
+		stopRPC, err := startRPC(c.rpcListen, newRPCServer(d, c.rpcSecret))
+		if err != nil {
+			return err
+		}
+		defer stopRPC()
+	}
+
+	slog.Info("daemon started", "socket", socketPath, "output", c.outputDir, "queued", len(d.entries))
+
+	d.schedule(ctx)
+
+	// let the running downloads save their part files
+	d.running.Wait()
+
+	d.mu.Lock()
+	d.save()
+	d.mu.Unlock()
+
+	slog.Info("daemon stopped")
+
+	return nil
+}
+
+// runClient sends a command to the daemon and prints its reply.
+func (c *CLIApplication) runClient(ctx context.Context, command string, args []string) error {
+	socketPath := filepath.Join(c.stateDir, daemonSocketFile)
+
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("daemon not reachable at %s, is `leech daemon` running? %w", socketPath, err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if _, err := fmt.Fprintln(conn, strings.Join(append([]string{command}, args...), " ")); err != nil {
+		return fmt.Errorf("failed to send command: %w", err)
+	}
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return fmt.Errorf("failed to read reply: %w", err)
+	}
+
+	text := strings.TrimRight(string(reply), "\n")
+	if msg, ok := strings.CutPrefix(text, "error: "); ok {
+		return fmt.Errorf("%w: %s", errDaemonReply, msg)
+	}
+
+	_, _ = fmt.Fprintln(c.Out, text)
+
+	return nil
+}
@@ -0,0 +1,266 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestDaemon(t *testing.T, app *CLIApplication) *daemon {
+	t.Helper()
+
+	app.outputDir = t.TempDir()
+	app.stateDir = t.TempDir()
+	if app.limiter == nil {
+		app.limiter = newRateLimiter(0)
+	}
+
+	return newDaemon(app)
+}
+
+func waitStatus(t *testing.T, d *daemon, id int, want string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		d.mu.Lock()
+		e, err := d.find(strconv.Itoa(id))
+		status := ""
+		if err == nil {
+			status = e.Status
+		}
+		d.mu.Unlock()
+
+		if status == want {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("download %d did not become %s", id, want)
+}
+
+func TestDaemonDownloadsQueue(t *testing.T) {
+	content := []byte("abcdefghijklmnopqrstuvwxyz0123456789")
+	ts := newTestServer(content, true)
+	defer ts.Close()
+
+	d := newTestDaemon(t, &CLIApplication{Client: ts.Client(), chunkSize: 3})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go d.schedule(ctx)
+
+	reply, err := d.command("add " + ts.URL + "/alphabet.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "added 1 "+ts.URL+"/alphabet.bin" {
+		t.Errorf("add reply = %q", reply)
+	}
+
+	waitStatus(t, d, 1, statusDone)
+
+	got, err := os.ReadFile(filepath.Join(d.app.outputDir, "alphabet.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(content) {
+		t.Errorf("content = %q, want %q", got, content)
+	}
+
+	list, _ := d.command("list")
+	if !strings.Contains(list, "done") || !strings.Contains(list, "alphabet.bin") {
+		t.Errorf("list = %q", list)
+	}
+
+	cancel()
+	d.running.Wait()
+
+	// the queue survives a restart
+	restarted := newDaemon(d.app)
+	if err := restarted.load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(restarted.entries) != 1 || restarted.entries[0].Status != statusDone {
+		t.Errorf("entries after restart = %+v", restarted.entries)
+	}
+	if restarted.nextID != 2 {
+		t.Errorf("nextID after restart = %d, want 2", restarted.nextID)
+	}
+}
+
+func TestDaemonLoadRequeuesActive(t *testing.T) {
+	d := newTestDaemon(t, &CLIApplication{})
+
+	queue := `[
+  {"id": 3, "url": "http://example.com/a.iso", "filename": "a.iso", "status": "active"},
+  {"id": 7, "url": "http://example.com/b.iso", "status": "paused"}
+]`
+	if err := os.WriteFile(d.path, []byte(queue), permFile); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.entries[0].Status != statusQueued {
+		t.Errorf("active entry status = %q, want %q", d.entries[0].Status, statusQueued)
+	}
+	if d.entries[1].Status != statusPaused {
+		t.Errorf("paused entry status = %q, want %q", d.entries[1].Status, statusPaused)
+	}
+	if d.nextID != 8 {
+		t.Errorf("nextID = %d, want 8", d.nextID)
+	}
+	if !d.names.used["a.iso"] {
+		t.Error("the name of a started download should stay reserved")
+	}
+}
+
+func TestDaemonCommands(t *testing.T) {
+	d := newTestDaemon(t, &CLIApplication{})
+
+	if _, err := d.command("add http://example.com/a.iso http://example.com/b.iso"); err != nil {
+		t.Fatal(err)
+	}
+
+	part := filepath.Join(d.app.outputDir, "b.iso.part")
+	d.entries[1].Filename = "b.iso"
+	if err := os.WriteFile(part, []byte("data"), permFile); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		line    string
+		want    string
+		wantErr error
+		fails   bool
+	}{
+		{line: "pause 1", want: "paused 1"},
+		{line: "pause 1", fails: true},
+		{line: "resume 1", want: "queued 1"},
+		{line: "remove 2", want: "removed 2"},
+		{line: "remove 2", wantErr: errUnknownID},
+		{line: "pause x", wantErr: errUnknownID},
+		{line: "limit 1M", want: "limit 1.0MB/s"},
+		{line: "reboot", wantErr: errUnknownCommand},
+		{line: "add garbage", wantErr: errEmptyURL},
+	}
+
+	for _, tt := range tests {
+		got, err := d.command(tt.line)
+
+		switch {
+		case tt.fails:
+			if err == nil {
+				t.Errorf("command(%q) should fail", tt.line)
+			}
+		case tt.wantErr != nil:
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("command(%q) error = %v, want %v", tt.line, err, tt.wantErr)
+			}
+		case err != nil:
+			t.Errorf("command(%q) error = %v", tt.line, err)
+		case got != tt.want:
+			t.Errorf("command(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+
+	if _, err := os.Stat(part); !os.IsNotExist(err) {
+		t.Error("removing a download should delete its part file")
+	}
+	if len(d.entries) != 1 {
+		t.Errorf("entries = %d, want 1", len(d.entries))
+	}
+}
+
+func TestDaemonFinish(t *testing.T) {
+	d := newTestDaemon(t, &CLIApplication{})
+
+	stopped, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	failed := errors.New("connection reset")
+
+	tests := []struct {
+		name   string
+		status string
+		ctx    context.Context
+		err    error
+		want   string
+	}{
+		{"completed", statusActive, context.Background(), nil, statusDone},
+		{"completed as the daemon stops", statusActive, stopped, nil, statusDone},
+		{"completed as it was paused", statusPaused, stopped, nil, statusDone},
+		{"failed", statusActive, context.Background(), failed, statusFailed},
+		{"cut short by the shutdown", statusActive, stopped, failed, statusQueued},
+		{"cut short by a pause", statusPaused, stopped, failed, statusPaused},
+	}
+
+	for _, tt := range tests {
+		e := &daemonEntry{ID: 1, URL: "http://example.com/a.iso", Status: tt.status, cancel: func() {}}
+		d.entries = []*daemonEntry{e}
+		d.active = 1
+
+		d.finish(tt.ctx, e, tt.err)
+
+		if e.Status != tt.want {
+			t.Errorf("%s: status = %q, want %q", tt.name, e.Status, tt.want)
+		}
+	}
+}
+
+func TestCheckClientFlags(t *testing.T) {
+	for _, tt := range []struct {
+		args    []string
+		wantErr bool
+	}{
+		{args: []string{"leech", "-state-dir", "/tmp/leech", "-verbose", "add", "http://example.com/a.iso"}},
+		{args: []string{"leech", "-output", "/tmp", "add", "http://example.com/a.iso"}, wantErr: true},
+	} {
+		flag.CommandLine = flag.NewFlagSet(tt.args[0], flag.ContinueOnError)
+
+		oldArgs := os.Args
+		os.Args = tt.args
+
+		app := &CLIApplication{}
+		if err := app.parseFlags(); err != nil {
+			t.Fatal(err)
+		}
+
+		os.Args = oldArgs
+
+		if err := checkClientFlags(); (err != nil) != tt.wantErr {
+			t.Errorf("checkClientFlags(%q) error = %v, want error %v", tt.args, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDaemonScheduleSavesOnlyChanges(t *testing.T) {
+	d := newTestDaemon(t, &CLIApplication{jobs: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go d.schedule(ctx)
+
+	// wake-ups that start nothing leave the queue file alone
+	for range 3 {
+		d.notify()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := os.Stat(d.path); !os.IsNotExist(err) {
+		t.Errorf("queue saved without a change: %v", err)
+	}
+}
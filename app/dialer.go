@@ -0,0 +1,256 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errInvalidBindAddress = errors.New("invalid bind address")
+	errNoInterfaceAddress = errors.New("interface has no usable address")
+	errInvalidResolve     = errors.New("invalid resolve entry")
+)
+
+const (
+	dialTimeout   = 30 * time.Second
+	dialKeepAlive = 30 * time.Second
+)
+
+// contextDialer is satisfied by net.Dialer and dialer.
+type contextDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// dialer opens every outgoing connection. With several local addresses,
+// new connections are spread across them round-robin, so parallel chunks
+// can use more than one uplink. overrides pin host:port pairs to fixed
+// addresses; only the dialed address changes, so the Host header and TLS
+// SNI still carry the original name. Names are resolved with doh when set.
+type dialer struct {
+	doh        *dohResolver
+	overrides  map[string]string
+	network    string // "tcp4" or "tcp6" forces an address family
+	localAddrs []net.IP
+	next       atomic.Uint64
+}
+
+func newDialer() *dialer {
+	return &dialer{}
+}
+
+func (d *dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	nd := net.Dialer{Timeout: dialTimeout, KeepAlive: dialKeepAlive}
+
+	if d.network != "" && strings.HasPrefix(network, "tcp") {
+		network = d.network
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", address, err)
+	}
+
+	if ip, ok := d.overrides[strings.ToLower(address)]; ok {
+		return d.dial(ctx, &nd, network, address, []string{net.JoinHostPort(ip, port)})
+	}
+
+	if d.doh == nil || net.ParseIP(host) != nil {
+		return d.dial(ctx, &nd, network, address, []string{address})
+	}
+
+	ips, err := d.doh.lookup(ctx, network, host)
+	if err != nil {
+		// an authoritative NXDOMAIN stands, asking the system resolver
+		// would bring back the answers DoH is there to avoid
+		if !d.doh.fallback || errors.Is(err, errDNSNotFound) {
+			return nil, fmt.Errorf("dial %s: %w", address, err)
+		}
+
+		slog.Debug("doh lookup failed, using system resolver", "host", host, logKeyError, err)
+
+		return d.dial(ctx, &nd, network, address, []string{address})
+	}
+
+	targets := make([]string, len(ips))
+	for i, ip := range ips {
+		targets[i] = net.JoinHostPort(ip.String(), port)
+	}
+
+	return d.dial(ctx, &nd, network, address, targets)
+}
+
+// dial tries targets in order and returns the first connection. Each
+// target is dialed from a local address of its family.
+func (d *dialer) dial(ctx context.Context, nd *net.Dialer, network, address string, targets []string) (net.Conn, error) {
+	var lastErr error
+
+	for _, target := range targets {
+		var ip net.IP
+		if host, _, err := net.SplitHostPort(target); err == nil {
+			ip = net.ParseIP(host)
+		}
+
+		nd.LocalAddr = nil
+		if local := d.localAddr(network, ip); local != nil {
+			nd.LocalAddr = &net.TCPAddr{IP: local}
+		}
+
+		conn, err := nd.DialContext(ctx, network, target)
+		if err != nil {
+			lastErr = err
+
+			continue
+		}
+
+		slog.Debug("connected",
+			"address", address,
+			"remote", conn.RemoteAddr().String(),
+			"local", conn.LocalAddr().String(),
+		)
+
+		return conn, nil
+	}
+
+	return nil, fmt.Errorf("dial %s: %w", address, lastErr)
+}
+
+// localAddr returns the next local address, round-robin, of the family
+// the connection will use: that of target if it is an address, else the
+// one the network forces, else IPv4 if there is one. A host name dialed
+// from an IPv4 address only resolves to IPv4 addresses. Without an address
+// of the family, every address takes part and the dial fails as it would.
+func (d *dialer) localAddr(network string, target net.IP) net.IP {
+	if len(d.localAddrs) == 0 {
+		return nil
+	}
+
+	var v4, v6 []net.IP
+	for _, ip := range d.localAddrs {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+
+	var wantV6 bool
+
+	switch {
+	case target != nil:
+		wantV6 = target.To4() == nil
+	case network == "tcp6":
+		wantV6 = true
+	case network == "tcp4":
+		wantV6 = false
+	default:
+		wantV6 = len(v4) == 0
+	}
+
+	candidates := v4
+	if wantV6 {
+		candidates = v6
+	}
+	if len(candidates) == 0 {
+		candidates = d.localAddrs
+	}
+
+	n := d.next.Add(1) - 1
+
+	return candidates[n%uint64(len(candidates))]
+}
+
+// parseBindAddresses parses a comma separated list of local IP addresses.
+func parseBindAddresses(s string) ([]net.IP, error) {
+	var ips []net.IP
+
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		ip := net.ParseIP(field)
+		if ip == nil {
+			return nil, fmt.Errorf("%w: %q", errInvalidBindAddress, field)
+		}
+
+		ips = append(ips, ip)
+	}
+
+	return ips, nil
+}
+
+// parseResolve parses curl style host:port:addr entries into a map keyed
+// by host:port. addr may be an IPv6 address, with or without brackets.
+func parseResolve(entries []string) (map[string]string, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	overrides := make(map[string]string, len(entries))
+
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("%w: %q, want host:port:addr", errInvalidResolve, entry)
+		}
+
+		host, port := strings.ToLower(parts[0]), parts[1]
+		addr := strings.TrimSuffix(strings.TrimPrefix(parts[2], "["), "]")
+
+		if host == "" || net.ParseIP(addr) == nil {
+			return nil, fmt.Errorf("%w: %q", errInvalidResolve, entry)
+		}
+
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return nil, fmt.Errorf("%w: %q, bad port", errInvalidResolve, entry)
+		}
+
+		overrides[net.JoinHostPort(host, port)] = addr
+	}
+
+	return overrides, nil
+}
+
+// interfaceAddresses returns the global unicast addresses of a network
+// interface, IPv4 first. Both families are kept, every connection is
+// bound to an address of the family it dials.
+func interfaceAddresses(name string) ([]net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid interface: %w", err)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read interface addresses: %w", err)
+	}
+
+	var v4, v6 []net.IP
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() && !ipNet.IP.IsLoopback() {
+			continue
+		}
+
+		if ipNet.IP.To4() != nil {
+			v4 = append(v4, ipNet.IP)
+		} else {
+			v6 = append(v6, ipNet.IP)
+		}
+	}
+
+	if len(v4)+len(v6) == 0 {
+		return nil, fmt.Errorf("%w: %s", errNoInterfaceAddress, name)
+	}
+
+	return append(v4, v6...), nil
+}
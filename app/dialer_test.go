@@ -0,0 +1,227 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestParseBindAddresses(t *testing.T) {
+	ips, err := parseBindAddresses("127.0.0.1, ::1,,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 2 {
+		t.Fatalf("expected 2 addresses, got %d", len(ips))
+	}
+	if !ips[1].Equal(net.IPv6loopback) {
+		t.Errorf("ips[1] = %v, want ::1", ips[1])
+	}
+
+	if _, err := parseBindAddresses("127.0.0.1,not-an-ip"); !errors.Is(err, errInvalidBindAddress) {
+		t.Errorf("expected errInvalidBindAddress, got %v", err)
+	}
+}
+
+func TestDialerRoundRobin(t *testing.T) {
+	d := &dialer{localAddrs: []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}}
+
+	got := []string{d.localAddr("tcp", nil).String(), d.localAddr("tcp", nil).String(), d.localAddr("tcp", nil).String()}
+	want := []string{"10.0.0.1", "10.0.0.2", "10.0.0.1"}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("localAddr #%d = %s, want %s", i, got[i], want[i])
+		}
+	}
+
+	if (&dialer{}).localAddr("tcp", nil) != nil {
+		t.Error("expected nil local address without bind addresses")
+	}
+}
+
+func TestDialerLocalAddrFamily(t *testing.T) {
+	d := &dialer{localAddrs: []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("2001:db8::1")}}
+
+	tests := []struct {
+		network string
+		target  string
+		want    string
+	}{
+		{"tcp", "", "10.0.0.1"},
+		{"tcp4", "", "10.0.0.1"},
+		{"tcp6", "", "2001:db8::1"},
+		{"tcp", "2001:db8::80", "2001:db8::1"},
+		{"tcp", "192.0.2.80", "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		if got := d.localAddr(tt.network, net.ParseIP(tt.target)); got.String() != tt.want {
+			t.Errorf("localAddr(%s, %q) = %s, want %s", tt.network, tt.target, got, tt.want)
+		}
+	}
+
+	// without an address of the family, the ones there are still apply
+	v4only := &dialer{localAddrs: []net.IP{net.ParseIP("10.0.0.1")}}
+	if got := v4only.localAddr("tcp6", nil); got.String() != "10.0.0.1" {
+		t.Errorf("localAddr(tcp6) = %s, want 10.0.0.1", got)
+	}
+}
+
+func TestDialerBindsLocalAddress(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Addr, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- conn.RemoteAddr()
+		conn.Close()
+	}()
+
+	d := &dialer{localAddrs: []net.IP{net.ParseIP("127.0.0.1")}}
+
+	conn, err := d.DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	remote := (<-accepted).(*net.TCPAddr)
+	if !remote.IP.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("connection came from %v, want 127.0.0.1", remote.IP)
+	}
+}
+
+func TestDialerBindsMatchingFamily(t *testing.T) {
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skip("no IPv6 loopback:", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	// a dual-stack interface lists its IPv4 address first
+	d := &dialer{localAddrs: []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")}}
+
+	conn, err := d.DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestInterfaceAddresses(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback == 0 {
+			continue
+		}
+
+		ips, err := interfaceAddresses(iface.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ips) == 0 {
+			t.Error("expected loopback interface to have addresses")
+		}
+
+		return
+	}
+
+	t.Skip("no loopback interface")
+}
+
+func TestInterfaceAddressesUnknown(t *testing.T) {
+	if _, err := interfaceAddresses("no-such-interface0"); err == nil {
+		t.Error("expected error for unknown interface")
+	}
+}
+
+func TestParseResolve(t *testing.T) {
+	overrides, err := parseResolve([]string{
+		"CDN.example.com:443:203.0.113.10",
+		"v6.example.com:80:[2001:db8::1]",
+		"plain.example.com:8080:2001:db8::2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"cdn.example.com:443":    "203.0.113.10",
+		"v6.example.com:80":      "2001:db8::1",
+		"plain.example.com:8080": "2001:db8::2",
+	}
+	for k, v := range want {
+		if overrides[k] != v {
+			t.Errorf("overrides[%q] = %q, want %q", k, overrides[k], v)
+		}
+	}
+
+	for _, bad := range []string{"example.com:443", "example.com:port:1.2.3.4", ":443:1.2.3.4", "example.com:443:host"} {
+		if _, err := parseResolve([]string{bad}); !errors.Is(err, errInvalidResolve) {
+			t.Errorf("parseResolve(%q) error = %v, want errInvalidResolve", bad, err)
+		}
+	}
+}
+
+func TestDialerResolveOverride(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	overrides, err := parseResolve([]string{"edge.invalid:" + port + ":127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &dialer{overrides: overrides}
+
+	conn, err := d.DialContext(context.Background(), "tcp", "edge.invalid:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestDialerForcedFamily(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	d := &dialer{network: "tcp6"}
+
+	if _, err := d.DialContext(context.Background(), "tcp", ln.Addr().String()); err == nil {
+		t.Error("expected IPv4 address to be rejected when IPv6 is forced")
+	}
+}
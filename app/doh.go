@@ -0,0 +1,274 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errDNSMessage  = errors.New("invalid dns message")
+	errDNSNotFound = errors.New("no such host")
+	errDNSFailure  = errors.New("dns query failed")
+)
+
+const (
+	dnsTypeA         = 1
+	dnsTypeAAAA      = 28
+	dnsClassIN       = 1
+	dnsHeaderLen     = 12
+	dnsFlagRD        = 0x0100
+	dnsFlagQR        = 0x8000
+	dnsRcodeMask     = 0x000f
+	dnsRcodeNXDomain = 3
+	dnsPointerMask   = 0xc0
+	dnsMaxMessage    = 64 * kilo
+	dohContentType   = "application/dns-message"
+	dohTimeout       = 10 * time.Second
+	dohMinTTL        = 5 * time.Second
+)
+
+// dohResolver resolves host names with DNS-over-HTTPS (RFC 8484) and
+// caches answers for their TTL.
+type dohResolver struct {
+	client   *http.Client
+	cache    map[string]dohCacheEntry
+	url      string
+	fallback bool
+	mu       sync.Mutex
+}
+
+type dohCacheEntry struct {
+	expires time.Time
+	ips     []net.IP
+}
+
+func newDoHResolver(url string, client *http.Client, fallback bool) *dohResolver {
+	return &dohResolver{
+		client:   client,
+		cache:    make(map[string]dohCacheEntry),
+		url:      url,
+		fallback: fallback,
+	}
+}
+
+// lookup returns the addresses of host for a tcp, tcp4 or tcp6 network.
+func (r *dohResolver) lookup(ctx context.Context, network, host string) ([]net.IP, error) {
+	var types []uint16
+
+	switch network {
+	case "tcp4":
+		types = []uint16{dnsTypeA}
+	case "tcp6":
+		types = []uint16{dnsTypeAAAA}
+	default:
+		types = []uint16{dnsTypeA, dnsTypeAAAA}
+	}
+
+	var (
+		ips     []net.IP
+		lastErr error
+	)
+
+	for _, qtype := range types {
+		found, err := r.lookupType(ctx, host, qtype)
+		if err != nil {
+			lastErr = err
+
+			continue
+		}
+
+		ips = append(ips, found...)
+	}
+
+	if len(ips) == 0 {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("%w: %s", errDNSNotFound, host)
+		}
+
+		return nil, lastErr
+	}
+
+	return ips, nil
+}
+
+func (r *dohResolver) lookupType(ctx context.Context, host string, qtype uint16) ([]net.IP, error) {
+	key := fmt.Sprintf("%s/%d", strings.ToLower(host), qtype)
+
+	r.mu.Lock()
+	entry, ok := r.cache[key]
+	r.mu.Unlock()
+
+	if ok && time.Now().Before(entry.expires) {
+		return entry.ips, nil
+	}
+
+	query, err := buildDNSQuery(host, qtype)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dohTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(query))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", dohContentType)
+	req.Header.Set("Accept", dohContentType)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errDNSFailure, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: doh server returned %d", errDNSFailure, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dnsMaxMessage))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errDNSFailure, err)
+	}
+
+	ips, ttl, err := parseDNSResponse(body, qtype)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", host, err)
+	}
+
+	slog.Debug("doh lookup", "host", host, "type", qtype, "answers", len(ips), "ttl", ttl)
+
+	r.mu.Lock()
+	r.cache[key] = dohCacheEntry{ips: ips, expires: time.Now().Add(max(ttl, dohMinTTL))}
+	r.mu.Unlock()
+
+	return ips, nil
+}
+
+// buildDNSQuery builds a recursive query for one name. The ID is zero as
+// RFC 8484 recommends, which keeps responses cacheable.
+func buildDNSQuery(host string, qtype uint16) ([]byte, error) {
+	msg := make([]byte, dnsHeaderLen, dnsHeaderLen+len(host)+6)
+	binary.BigEndian.PutUint16(msg[2:], dnsFlagRD)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if label == "" || len(label) > 63 {
+			return nil, fmt.Errorf("%w: bad host name %q", errDNSMessage, host)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, dnsClassIN)
+
+	return msg, nil
+}
+
+// parseDNSResponse returns the addresses of type qtype in the answer
+// section and the smallest TTL among them.
+func parseDNSResponse(msg []byte, qtype uint16) ([]net.IP, time.Duration, error) {
+	if len(msg) < dnsHeaderLen {
+		return nil, 0, fmt.Errorf("%w: short header", errDNSMessage)
+	}
+
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&dnsFlagQR == 0 {
+		return nil, 0, fmt.Errorf("%w: not a response", errDNSMessage)
+	}
+
+	switch rcode := flags & dnsRcodeMask; rcode {
+	case 0:
+	case dnsRcodeNXDomain:
+		return nil, 0, errDNSNotFound
+	default:
+		return nil, 0, fmt.Errorf("%w: rcode %d", errDNSFailure, rcode)
+	}
+
+	qdCount := binary.BigEndian.Uint16(msg[4:])
+	anCount := binary.BigEndian.Uint16(msg[6:])
+	off := dnsHeaderLen
+
+	var err error
+
+	for range qdCount {
+		if off, err = skipDNSName(msg, off); err != nil {
+			return nil, 0, err
+		}
+		off += 4 // type, class
+	}
+
+	var (
+		ips    []net.IP
+		minTTL time.Duration
+	)
+
+	for range anCount {
+		if off, err = skipDNSName(msg, off); err != nil {
+			return nil, 0, err
+		}
+
+		if off+10 > len(msg) {
+			return nil, 0, fmt.Errorf("%w: truncated answer", errDNSMessage)
+		}
+
+		rtype := binary.BigEndian.Uint16(msg[off:])
+		ttl := time.Duration(binary.BigEndian.Uint32(msg[off+4:])) * time.Second
+		rdLen := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+
+		if off+rdLen > len(msg) {
+			return nil, 0, fmt.Errorf("%w: truncated record data", errDNSMessage)
+		}
+
+		rdata := msg[off : off+rdLen]
+		off += rdLen
+
+		if rtype != qtype || (qtype == dnsTypeA && rdLen != net.IPv4len) || (qtype == dnsTypeAAAA && rdLen != net.IPv6len) {
+			continue
+		}
+
+		ips = append(ips, net.IP(bytes.Clone(rdata)))
+		if minTTL == 0 || ttl < minTTL {
+			minTTL = ttl
+		}
+	}
+
+	if len(ips) == 0 {
+		return nil, 0, errDNSNotFound
+	}
+
+	return ips, minTTL, nil
+}
+
+func skipDNSName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, fmt.Errorf("%w: truncated name", errDNSMessage)
+		}
+
+		n := int(msg[off])
+
+		switch {
+		case n == 0:
+			return off + 1, nil
+		case n&dnsPointerMask == dnsPointerMask:
+			return off + 2, nil
+		default:
+			off += n + 1
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package app
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// dnsTestAnswer builds a response to query with one answer per ip.
+func dnsTestAnswer(query []byte, ips []net.IP, ttl uint32, rcode uint16) []byte {
+	qtype := binary.BigEndian.Uint16(query[len(query)-4:])
+
+	resp := append([]byte{}, query...)
+	binary.BigEndian.PutUint16(resp[2:], dnsFlagQR|dnsFlagRD|rcode)
+
+	var count uint16
+	for _, ip := range ips {
+		rdata := ip.To4()
+		rtype := uint16(dnsTypeA)
+		if rdata == nil {
+			rdata, rtype = ip.To16(), dnsTypeAAAA
+		}
+		if rtype != qtype {
+			continue
+		}
+
+		resp = append(resp, 0xc0, dnsHeaderLen) // pointer to the question name
+		resp = binary.BigEndian.AppendUint16(resp, rtype)
+		resp = binary.BigEndian.AppendUint16(resp, dnsClassIN)
+		resp = binary.BigEndian.AppendUint32(resp, ttl)
+		resp = binary.BigEndian.AppendUint16(resp, uint16(len(rdata)))
+		resp = append(resp, rdata...)
+		count++
+	}
+
+	binary.BigEndian.PutUint16(resp[6:], count)
+
+	return resp
+}
+
+func newTestDoHServer(t *testing.T, records map[string][]net.IP, queries *atomic.Int32) *httptest.Server {
+	t.Helper()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != dohContentType {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+
+			return
+		}
+
+		query, _ := io.ReadAll(r.Body)
+		queries.Add(1)
+
+		// decode the single question name
+		var name string
+		for off := dnsHeaderLen; query[off] != 0; off += int(query[off]) + 1 {
+			if name != "" {
+				name += "."
+			}
+			name += string(query[off+1 : off+1+int(query[off])])
+		}
+
+		ips, ok := records[name]
+		rcode := uint16(0)
+		if !ok {
+			rcode = dnsRcodeNXDomain
+		}
+
+		w.Header().Set("Content-Type", dohContentType)
+		w.Write(dnsTestAnswer(query, ips, 300, rcode))
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func TestBuildAndParseDNS(t *testing.T) {
+	query, err := buildDNSQuery("www.example.com.", dnsTypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := dnsTestAnswer(query, []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")}, 60, 0)
+
+	ips, ttl, err := parseDNSResponse(resp, dnsTypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 2 || !ips[1].Equal(net.ParseIP("192.0.2.2")) {
+		t.Errorf("ips = %v", ips)
+	}
+	if ttl != time.Minute {
+		t.Errorf("ttl = %v, want 1m", ttl)
+	}
+
+	if _, err := buildDNSQuery("bad..name", dnsTypeA); !errors.Is(err, errDNSMessage) {
+		t.Errorf("expected errDNSMessage for empty label, got %v", err)
+	}
+}
+
+func TestParseDNSResponseErrors(t *testing.T) {
+	query, _ := buildDNSQuery("example.com", dnsTypeA)
+
+	if _, _, err := parseDNSResponse(query, dnsTypeA); !errors.Is(err, errDNSMessage) {
+		t.Errorf("query instead of response: got %v", err)
+	}
+
+	nx := dnsTestAnswer(query, nil, 0, dnsRcodeNXDomain)
+	if _, _, err := parseDNSResponse(nx, dnsTypeA); !errors.Is(err, errDNSNotFound) {
+		t.Errorf("nxdomain: got %v", err)
+	}
+
+	truncated := dnsTestAnswer(query, []net.IP{net.ParseIP("192.0.2.1")}, 60, 0)
+	if _, _, err := parseDNSResponse(truncated[:len(truncated)-2], dnsTypeA); !errors.Is(err, errDNSMessage) {
+		t.Errorf("truncated: got %v", err)
+	}
+}
+
+func TestDoHResolverCaches(t *testing.T) {
+	var queries atomic.Int32
+	ts := newTestDoHServer(t, map[string][]net.IP{
+		"files.example": {net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+	}, &queries)
+
+	r := newDoHResolver(ts.URL, ts.Client(), false)
+
+	ips, err := r.lookup(context.Background(), "tcp", "files.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 2 {
+		t.Errorf("expected A and AAAA answers, got %v", ips)
+	}
+
+	if _, err := r.lookup(context.Background(), "tcp", "files.example"); err != nil {
+		t.Fatal(err)
+	}
+	if n := queries.Load(); n != 2 {
+		t.Errorf("queries = %d, want 2 (second lookup cached)", n)
+	}
+
+	if _, err := r.lookup(context.Background(), "tcp4", "missing.example"); !errors.Is(err, errDNSNotFound) {
+		t.Errorf("expected errDNSNotFound, got %v", err)
+	}
+}
+
+func TestDownloadThroughDoH(t *testing.T) {
+	content := []byte("resolved via doh")
+	files := newTestServer(content, false)
+	defer files.Close()
+
+	var queries atomic.Int32
+	doh := newTestDoHServer(t, map[string][]net.IP{"files.example": {net.ParseIP("127.0.0.1")}}, &queries)
+
+	d := &dialer{doh: newDoHResolver(doh.URL, doh.Client(), false)}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = d.DialContext
+
+	_, port, _ := net.SplitHostPort(files.Listener.Addr().String())
+
+	app := &CLIApplication{Client: &http.Client{Transport: transport}, chunkSize: 1}
+
+	r, err := app.getResourceInformation(context.Background(), "http://files.example:"+port+"/file.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.length != int64(len(content)) {
+		t.Errorf("length = %d, want %d", r.length, len(content))
+	}
+	if queries.Load() == 0 {
+		t.Error("expected the DoH server to be queried")
+	}
+
+	// unknown names fail without fallback
+	if _, err := app.getResourceInformation(context.Background(), "http://nowhere.example:"+port+"/f"); err == nil {
+		t.Error("expected lookup failure without fallback")
+	}
+}
+
+func TestDialerDoHFallback(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	// unreachable DoH endpoint, the system resolver knows localhost
+	d := &dialer{
+		network: "tcp4",
+		doh:     newDoHResolver("http://127.0.0.1:1/dns-query", http.DefaultClient, true),
+	}
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	conn, err := d.DialContext(context.Background(), "tcp", "localhost:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestDialerDoHFallbackKeepsNXDomain(t *testing.T) {
+	var queries atomic.Int32
+	doh := newTestDoHServer(t, map[string][]net.IP{}, &queries)
+
+	// the system resolver knows localhost, the DoH server says it does not exist
+	d := &dialer{network: "tcp4", doh: newDoHResolver(doh.URL, doh.Client(), true)}
+
+	conn, err := d.DialContext(context.Background(), "tcp", "localhost:1")
+	if err == nil {
+		conn.Close()
+	}
+	if !errors.Is(err, errDNSNotFound) {
+		t.Errorf("error = %v, want %v without falling back", err, errDNSNotFound)
+	}
+	if queries.Load() == 0 {
+		t.Error("expected the DoH server to be queried")
+	}
+}
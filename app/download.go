@@ -5,8 +5,6 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
-	"mime"
-	"net/http"
 	neturl "net/url"
 	"os"
 	"path/filepath"
@@ -22,59 +20,162 @@ const (
 	logKeyFile             = "file"
 )
 
+// probeTimeout bounds a probe once it has its connection; a variable so
+// the tests can shorten it.
+var probeTimeout = 5 * time.Second
+
 type resource struct {
+	modTime     time.Time
+	hls         *hlsMedia
 	chunks      [][2]int64
 	url         string
+	dir         string
 	filename    string
 	contentType string
 	length      int64
+	limiter     *rateLimiter // per-file limit, nil without -limit-per-file
+	offset      int64        // first remote byte of a -range download
+	priority    int          // priority= from the input, higher starts first
+	ranged      bool
 }
 
-type downloadResult struct {
-	size int64
-	ok   bool
+// path returns the output path of the resource relative to the output
+// directory.
+func (r *resource) path() string {
+	return filepath.Join(filepath.FromSlash(r.dir), r.filename)
 }
 
-func (c *CLIApplication) getResourceInformation(ctx context.Context, url string) (*resource, error) {
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
+// urlHint carries per-URL settings from the input stage to the resource,
+// such as the directory a crawled file is mirrored into or the name of a
+// feed enclosure.
+type urlHint struct {
+	dir      string
+	filename string
+	priority int
+}
+
+func (c *CLIApplication) setHint(url string, h urlHint) {
+	c.hintsMu.Lock()
+	defer c.hintsMu.Unlock()
+
+	if c.hints == nil {
+		c.hints = make(map[string]urlHint)
+	}
+
+	c.hints[url] = h
+}
+
+// setPriority sets the priority= of url from the input, keeping the rest
+// of its hint.
+func (c *CLIApplication) setPriority(url string, priority int) {
+	c.hintsMu.Lock()
+	defer c.hintsMu.Unlock()
+
+	if c.hints == nil {
+		c.hints = make(map[string]urlHint)
+	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	h := c.hints[url]
+	h.priority = priority
+	c.hints[url] = h
+}
+
+func (c *CLIApplication) hint(url string) urlHint {
+	c.hintsMu.Lock()
+	defer c.hintsMu.Unlock()
+
+	return c.hints[url]
+}
+
+// dropHint forgets the hint of url once its resource is named, so a long
+// piped list or a daemon that runs for weeks doesn't keep them all.
+func (c *CLIApplication) dropHint(url string) {
+	c.hintsMu.Lock()
+	defer c.hintsMu.Unlock()
+
+	delete(c.hints, url)
+}
+
+// probeOnce sends one probe. The timeout starts once the connection slot is
+// taken, waiting for it while downloads hold them is not the probe timing
+// out either.
+func (c *CLIApplication) probeOnce(ctx context.Context, f fetcher, url string) (*remoteInfo, error) {
+	release, err := c.acquireConn(ctx, url)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	return f.probe(withHeldConn(ctx), url)
+}
 
-	resp, err := c.Client.Do(req)
+type downloadResult struct {
+	size     int64
+	ok       bool
+	canceled bool // from the keyboard, not a failure
+}
+
+func (c *CLIApplication) getResourceInformation(ctx context.Context, url string) (*resource, error) {
+	f, err := c.fetcherFor(url)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute request: %w", err)
+		return nil, err
+	}
+
+	var info *remoteInfo
+
+	// a probe answered with 429 is sent again once the host may be asked
+	for attempt := 0; ; attempt++ {
+		// the turn of the host comes first, waiting for it is not the
+		// probe timing out
+		if err := c.pacer.wait(ctx, url); err != nil {
+			return nil, err
+		}
+
+		info, err = c.probeOnce(ctx, f, url)
+		if !isTooManyRequests(err) || attempt == paceMaxRetries || c.pacer == nil {
+			break
+		}
 	}
-	defer func() { _ = resp.Body.Close() }()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("%w: returned %d", errHTTPStatusIsNotOK, resp.StatusCode)
+	if err != nil {
+		return nil, err
 	}
 
 	r := &resource{
-		url:    url,
-		length: resp.ContentLength,
+		url:         url,
+		length:      info.length,
+		contentType: info.contentType,
+		filename:    info.filename,
+		modTime:     info.modTime,
+	}
+
+	if c.fileRate > 0 {
+		r.limiter = newRateLimiter(c.fileRate)
+		r.limiter.setBurst(c.burst)
 	}
 
-	acceptRanges, ok := resp.Header["Accept-Ranges"]
-	if ok && resp.ContentLength > 0 && len(acceptRanges) > 0 && acceptRanges[0] == "bytes" {
-		r.chunks = getChunks(resp.ContentLength, c.chunkSize)
+	if h := c.hint(url); h != (urlHint{}) {
+		r.dir = h.dir
+		r.priority = h.priority
+		if h.filename != "" {
+			r.filename = h.filename
+		}
 	}
 
-	if ct, ok := resp.Header["Content-Type"]; ok {
-		r.contentType = ct[0]
+	if isHLS(url, info.contentType) {
+		if err := c.prepareHLS(ctx, r); err != nil {
+			return nil, err
+		}
+	} else if info.ranges && info.length > 0 {
+		r.chunks = getChunks(info.length, c.chunkSize)
 	}
 
-	if cd, ok := resp.Header["Content-Disposition"]; ok {
-		_, params, err := mime.ParseMediaType(cd[0])
-		if err == nil {
-			name := filepath.Base(params["filename"])
-			if name != "." && name != "/" {
-				r.filename = name
-			}
+	if c.byteRange != nil {
+		if err := c.applyRange(r, info); err != nil {
+			return nil, err
 		}
 	}
 
@@ -101,46 +202,122 @@ func (c *CLIApplication) getResourceInformation(ctx context.Context, url string)
 }
 
 func (c *CLIApplication) download(ctx context.Context, r *resource, done chan downloadResult, pd *progressDisplay) {
-	var success bool
+	var success, canceled bool
 	defer func() {
 		var size int64
 		if success {
 			size = max(r.length, 0)
 		}
-		done <- downloadResult{size: size, ok: success}
+		done <- downloadResult{size: size, ok: success, canceled: canceled}
 	}()
 
-	outputPath := filepath.Join(c.outputDir, r.filename)
+	var downloaded atomic.Int64
+	control := newTransferControl()
+	pd.addTransfer(r.path(), &downloaded, r.length, control)
+
+	if c.jobs > 0 {
+		// with a queue, only the active downloads keep a progress line
+		defer pd.remove(&downloaded)
+	}
+
+	success = control.run(ctx, func(ctx context.Context) bool {
+		return c.fetchResource(ctx, r, &downloaded)
+	})
+
+	if !success && control.current() == transferCanceled {
+		canceled = true
+
+		partPath := filepath.Join(c.outputDir, r.path()) + ".part"
+		_ = os.Remove(partPath)
+		_ = os.Remove(partPath + chunkStateSuffix)
+
+		slog.Info("download canceled", logKeyFile, r.path())
+	}
+}
+
+// fetchResource downloads r into the output directory, continuing from its
+// part file if there is one, and reports whether it completed.
+func (c *CLIApplication) fetchResource(ctx context.Context, r *resource, downloaded *atomic.Int64) bool {
+	outputPath := filepath.Join(c.outputDir, r.path())
 	partPath := outputPath + ".part"
 
-	var downloaded atomic.Int64
-	pd.add(r.filename, &downloaded, r.length)
+	if r.dir != "" {
+		if err := os.MkdirAll(filepath.Dir(outputPath), permDir); err != nil {
+			slog.Error("failed to create directory", logKeyFile, r.path(), logKeyError, err)
+			return false
+		}
+	}
 
-	if r.chunks != nil {
-		if ok := c.downloadChunked(ctx, r, outputPath, partPath, &downloaded); !ok {
+	if err := c.pacer.wait(ctx, r.url); err != nil {
+		slog.Info("download stopped", logKeyURL, r.url)
+		return false
+	}
+
+	if r.hls != nil {
+		if err := c.downloadHLS(ctx, r, outputPath, partPath, downloaded); err != nil {
+			if ctx.Err() != nil {
+				slog.Info("download stopped", logKeyURL, r.url)
+				return false
+			}
+
+			slog.Error("hls download failed", logKeyURL, r.url, logKeyError, err)
+			return false
+		}
+	} else if r.chunks != nil {
+		ok := c.downloadChunked(ctx, r, outputPath, partPath, downloaded)
+		if !ok && ctx.Err() != nil {
+			// stopped, the part file and its chunk state are kept for later
+			slog.Info("download stopped", logKeyURL, r.url)
+			return false
+		}
+
+		if !ok && r.ranged {
+			slog.Error("range download failed", logKeyURL, r.url)
+			return false
+		}
+
+		if !ok {
 			slog.Warn("chunked download failed, falling back to single stream", logKeyURL, r.url)
 			_ = os.Remove(partPath)
+			_ = os.Remove(partPath + chunkStateSuffix)
 			downloaded.Store(0)
 
-			if err := c.downloadSingle(ctx, r, outputPath, partPath, &downloaded); err != nil {
+			if err := c.downloadSingle(ctx, r, outputPath, partPath, downloaded); err != nil {
+				if ctx.Err() != nil {
+					slog.Info("download stopped", logKeyURL, r.url)
+					return false
+				}
+
 				slog.Error("single download fallback failed", logKeyURL, r.url, logKeyError, err)
-				return
+				return false
 			}
 		}
 	} else {
-		if err := c.downloadSingle(ctx, r, outputPath, partPath, &downloaded); err != nil {
+		if err := c.downloadSingle(ctx, r, outputPath, partPath, downloaded); err != nil {
+			if ctx.Err() != nil {
+				// stopped, the part file is kept for later
+				slog.Info("download stopped", logKeyURL, r.url)
+				return false
+			}
+
 			slog.Error("single download failed", logKeyURL, r.url, logKeyError, err)
-			return
+			return false
 		}
 	}
 
-	success = true
+	if !r.modTime.IsZero() {
+		if err := os.Chtimes(outputPath, r.modTime, r.modTime); err != nil {
+			slog.Debug("failed to set modification time", logKeyFile, r.filename, logKeyError, err)
+		}
+	}
 
 	if r.length > 0 {
 		slog.Info("download complete", logKeyFile, r.filename, "size", formatBytes(r.length))
 	} else {
 		slog.Info("download complete", logKeyFile, r.filename)
 	}
+
+	return true
 }
 
 func (c *CLIApplication) downloadChunked(
@@ -159,7 +336,11 @@ func (c *CLIApplication) downloadChunked(
 		return false
 	}
 
-	// only truncate if file size doesn't match expected length
+	statePath := partPath + chunkStateSuffix
+	done := make([]int64, len(r.chunks))
+
+	// only truncate if file size doesn't match expected length, otherwise
+	// continue the chunks where the last run left them
 	info, statErr := f.Stat()
 	if statErr != nil || info.Size() != r.length {
 		if err := f.Truncate(r.length); err != nil {
@@ -167,11 +348,25 @@ func (c *CLIApplication) downloadChunked(
 			slog.Error("failed to allocate part file", "path", partPath, logKeyError, err)
 			return false
 		}
+	} else {
+		done = loadChunkState(statePath, r)
 	}
 
 	_ = f.Close()
 
+	counters := make([]atomic.Int64, len(r.chunks))
+	for i := range counters {
+		counters[i].Store(done[i])
+	}
+
+	stopTracking := trackChunks(partPath, r, counters, downloaded)
+
 	for i, chunkPair := range r.chunks {
+		chunkPair[0] += done[i]
+		if chunkPair[0] > chunkPair[1] {
+			continue
+		}
+
 		wg.Go(func() {
 			chunkFile, err := os.OpenFile(partPath, os.O_WRONLY, permFile)
 			if err != nil {
@@ -184,11 +379,15 @@ func (c *CLIApplication) downloadChunked(
 				return
 			}
 
-			chunkErr := c.fetchToFile(chunkCtx, r.url, chunkPair, chunkFile, downloaded)
+			chunkErr := c.fetchToFile(chunkCtx, r, chunkPair, chunkFile, &counters[i])
 			_ = chunkFile.Close()
 
 			if chunkErr != nil {
-				slog.Error("chunk download failed", logKeyURL, r.url, "part", i, logKeyError, chunkErr)
+				// once stopped, or after another chunk failed, every chunk
+				// ends with context canceled; only the cause is worth an error
+				if chunkCtx.Err() == nil {
+					slog.Error("chunk download failed", logKeyURL, r.url, "part", i, logKeyError, chunkErr)
+				}
 				errMu.Lock()
 				fetchErr = chunkErr
 				errMu.Unlock()
@@ -201,6 +400,7 @@ func (c *CLIApplication) downloadChunked(
 		})
 	}
 	wg.Wait()
+	stopTracking()
 
 	if fetchErr != nil {
 		return false
@@ -211,37 +411,32 @@ func (c *CLIApplication) downloadChunked(
 		return false
 	}
 
+	_ = os.Remove(statePath)
+
 	return true
 }
 
 func (c *CLIApplication) downloadSingle(
 	ctx context.Context, r *resource, outputPath, partPath string, downloaded *atomic.Int64,
 ) error {
-	offset := getResumeOffset(partPath)
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	f, err := c.fetcherFor(r.url)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return err
 	}
 
+	offset := getResumeOffset(partPath)
 	if offset > 0 {
-		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
 		slog.Info("resuming download", logKeyFile, r.filename, "offset", formatBytes(offset))
 	}
 
-	resp, err := c.Client.Do(req)
+	body, start, err := f.openStream(ctx, r.url, offset)
 	if err != nil {
-		return fmt.Errorf("failed to execute request: %w", err)
-	}
-	defer func() { _ = resp.Body.Close() }()
-
-	// reject non-success responses
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
-		return fmt.Errorf("%w: returned %d", errHTTPStatusIsNotOK, resp.StatusCode)
+		return err
 	}
+	defer func() { _ = body.Close() }()
 
-	// if server didn't honor Range request, restart from scratch
-	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+	// if server didn't honor the resume offset, restart from scratch
+	if offset > 0 && start != offset {
 		slog.Info("server ignored range request, restarting", logKeyFile, r.filename)
 		offset = 0
 	}
@@ -253,61 +448,49 @@ func (c *CLIApplication) downloadSingle(
 		openFlag |= os.O_TRUNC
 	}
 
-	f, err := os.OpenFile(partPath, openFlag, permFile)
+	out, err := os.OpenFile(partPath, openFlag, permFile)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %w", err)
 	}
-	defer func() { _ = f.Close() }()
+	defer func() { _ = out.Close() }()
 
 	downloaded.Store(offset)
 
-	var reader io.Reader = resp.Body
-
-	if c.limiter != nil {
-		reader = &rateLimitedReader{reader: reader, limiter: c.limiter}
-	}
-
+	reader := c.throttle(ctx, body, r.url, r.limiter)
 	reader = &countingReader{reader: reader, counter: downloaded}
 
-	if _, err := io.Copy(f, reader); err != nil {
+	if _, err := io.Copy(out, reader); err != nil {
 		return fmt.Errorf("failed to write file: %w", err)
 	}
 
 	return finalizePart(partPath, outputPath)
 }
 
+// fetchToFile writes the remote bytes of chunk to f, which starts at
+// r.offset of the remote file.
 func (c *CLIApplication) fetchToFile(
-	ctx context.Context, url string, chunk [2]int64, f *os.File, downloaded *atomic.Int64,
+	ctx context.Context, r *resource, chunk [2]int64, f *os.File, downloaded *atomic.Int64,
 ) error {
 	start, end := chunk[0], chunk[1]
 	chunkBytes := end - start + 1
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	backend, err := c.fetcherFor(r.url)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return err
 	}
-	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
 
-	resp, err := c.Client.Do(req)
+	body, err := backend.openRange(ctx, r.url, start, end)
 	if err != nil {
-		return fmt.Errorf("failed to execute request: %w", err)
-	}
-	defer func() { _ = resp.Body.Close() }()
-
-	if resp.StatusCode != http.StatusPartialContent {
-		return fmt.Errorf("chunk fetch failed: expected 206, got %d", resp.StatusCode)
+		return err
 	}
+	defer func() { _ = body.Close() }()
 
-	slog.Debug("fetch response", logKeyURL, url, "status", resp.StatusCode, "range", fmt.Sprintf("%d-%d", start, end))
+	slog.Debug("fetch response", logKeyURL, r.url, "range", fmt.Sprintf("%d-%d", start, end))
 
-	var reader io.Reader = resp.Body
-	if c.limiter != nil {
-		reader = &rateLimitedReader{reader: reader, limiter: c.limiter}
-	}
-
-	reader = &countingReader{reader: reader, counter: downloaded}
+	reader := c.throttle(ctx, body, r.url, r.limiter)
 
-	writer := io.NewOffsetWriter(f, start)
+	// counted once written, the chunk state relies on it
+	writer := &countingWriter{writer: io.NewOffsetWriter(f, start-r.offset), counter: downloaded}
 
 	written, err := io.Copy(writer, reader)
 	if err != nil {
@@ -148,7 +148,8 @@ func TestFetchToFile(t *testing.T) {
 	}
 
 	var counter atomic.Int64
-	if err := app.fetchToFile(context.Background(), ts.URL+"/file.bin", [2]int64{0, 7}, f, &counter); err != nil {
+	r := &resource{url: ts.URL + "/file.bin"}
+	if err := app.fetchToFile(context.Background(), r, [2]int64{0, 7}, f, &counter); err != nil {
 		t.Fatal(err)
 	}
 
@@ -185,7 +186,8 @@ func TestFetchToFileMiddleChunk(t *testing.T) {
 	}
 
 	var counter atomic.Int64
-	if err := app.fetchToFile(context.Background(), ts.URL+"/file.bin", [2]int64{4, 9}, f, &counter); err != nil {
+	r := &resource{url: ts.URL + "/file.bin"}
+	if err := app.fetchToFile(context.Background(), r, [2]int64{4, 9}, f, &counter); err != nil {
 		t.Fatal(err)
 	}
 
@@ -442,7 +444,7 @@ func TestFetchToFileNon206(t *testing.T) {
 	defer func() { _ = f.Close() }()
 
 	var counter atomic.Int64
-	err = app.fetchToFile(context.Background(), ts.URL+"/file.bin", [2]int64{0, 7}, f, &counter)
+	err = app.fetchToFile(context.Background(), &resource{url: ts.URL + "/file.bin"}, [2]int64{0, 7}, f, &counter)
 	if err == nil {
 		t.Error("expected error for non-206 response")
 	}
@@ -467,7 +469,7 @@ func TestFetchToFileCancelledContext(t *testing.T) {
 	cancel()
 
 	var counter atomic.Int64
-	err = app.fetchToFile(ctx, ts.URL+"/file.bin", [2]int64{0, 3}, f, &counter)
+	err = app.fetchToFile(ctx, &resource{url: ts.URL + "/file.bin"}, [2]int64{0, 3}, f, &counter)
 	if err == nil {
 		t.Error("expected error for cancelled context")
 	}
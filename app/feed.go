@@ -0,0 +1,327 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	neturl "net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var errInvalidFeed = errors.New("invalid feed")
+
+const (
+	feedMaxSize     = 32 * mega
+	feedMaxTitleLen = 150
+	feedDateFormat  = "2006-01-02"
+)
+
+// feedDateLayouts are the date formats seen in the wild, RFC 822 variants
+// for RSS and RFC 3339 for Atom.
+var feedDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"Mon, 2 Jan 2006 15:04 -0700",
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 MST",
+	time.RFC3339,
+	"2006-01-02",
+}
+
+// feedOptions configures feed mode.
+type feedOptions struct {
+	urls    []string
+	newest  int  // only the newest N enclosures, 0 means all
+	onlyNew bool // skip enclosures whose file already exists
+}
+
+// feedDocument covers both RSS 2.0 (channel>item) and Atom (entry).
+type feedDocument struct {
+	Base         string   `xml:"http://www.w3.org/XML/1998/namespace base,attr"`
+	ChannelLinks []string `xml:"channel>link"`
+	Links        []struct {
+		Rel  string `xml:"rel,attr"`
+		Href string `xml:"href,attr"`
+	} `xml:"link"`
+	Items []struct {
+		Title      string `xml:"title"`
+		PubDate    string `xml:"pubDate"`
+		Enclosures []struct {
+			URL  string `xml:"url,attr"`
+			Type string `xml:"type,attr"`
+		} `xml:"enclosure"`
+	} `xml:"channel>item"`
+	Entries []struct {
+		Title     string `xml:"title"`
+		Published string `xml:"published"`
+		Updated   string `xml:"updated"`
+		Links     []struct {
+			Rel  string `xml:"rel,attr"`
+			Href string `xml:"href,attr"`
+			Type string `xml:"type,attr"`
+		} `xml:"link"`
+	} `xml:"entry"`
+}
+
+// feedEnclosure is one downloadable file of a feed entry.
+type feedEnclosure struct {
+	published time.Time
+	url       string
+	title     string
+	mimeType  string
+}
+
+// parseFeed returns the enclosures of an RSS or Atom feed, newest first.
+// Relative enclosure links are resolved against the xml:base of the feed,
+// else against base, the URL the feed came from. A feed read from a file
+// has no such URL, its own link to the site stands in. Relative links
+// that still can't be resolved are left out.
+func parseFeed(data []byte, base *neturl.URL) ([]feedEnclosure, error) {
+	var doc feedDocument
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+	dec.Entity = xml.HTMLEntity
+	dec.CharsetReader = feedCharsetReader
+
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidFeed, err)
+	}
+
+	base = doc.base(base)
+
+	var enclosures []feedEnclosure
+
+	add := func(ref, title, date, mimeType string) {
+		u, err := base.Parse(strings.TrimSpace(ref))
+		if err != nil || ref == "" {
+			return
+		}
+
+		if !u.IsAbs() {
+			slog.Warn("skipping relative enclosure, the feed has no base url", logKeyURL, ref)
+
+			return
+		}
+
+		enclosures = append(enclosures, feedEnclosure{
+			url:       u.String(),
+			title:     strings.TrimSpace(title),
+			published: parseFeedDate(date),
+			mimeType:  mimeType,
+		})
+	}
+
+	for _, item := range doc.Items {
+		for _, enc := range item.Enclosures {
+			add(enc.URL, item.Title, item.PubDate, enc.Type)
+		}
+	}
+
+	for _, entry := range doc.Entries {
+		date := entry.Published
+		if date == "" {
+			date = entry.Updated
+		}
+
+		for _, link := range entry.Links {
+			if link.Rel == "enclosure" {
+				add(link.Href, entry.Title, date, link.Type)
+			}
+		}
+	}
+
+	sort.SliceStable(enclosures, func(i, j int) bool {
+		return enclosures[i].published.After(enclosures[j].published)
+	})
+
+	return enclosures, nil
+}
+
+// base returns the URL relative links of the feed resolve against.
+func (doc *feedDocument) base(from *neturl.URL) *neturl.URL {
+	if !from.IsAbs() {
+		// the URL the feed says it is at, else the site it belongs to
+		var links []string
+		for _, rel := range []string{"self", "alternate", ""} {
+			for _, link := range doc.Links {
+				if link.Rel == rel {
+					links = append(links, link.Href)
+				}
+			}
+		}
+		links = append(links, doc.ChannelLinks...)
+
+		for _, link := range links {
+			if u, err := from.Parse(strings.TrimSpace(link)); err == nil && u.IsAbs() {
+				from = u
+
+				break
+			}
+		}
+	}
+
+	if doc.Base != "" {
+		if u, err := from.Parse(strings.TrimSpace(doc.Base)); err == nil {
+			return u
+		}
+	}
+
+	return from
+}
+
+// feedCharsetReader decodes the single byte charsets older feeds still
+// declare. Anything else is passed through as UTF-8.
+func feedCharsetReader(label string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(label) {
+	case "iso-8859-1", "iso8859-1", "latin1", "windows-1252":
+		data, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+
+		return strings.NewReader(string(runes)), nil
+	}
+
+	return input, nil
+}
+
+func parseFeedDate(s string) time.Time {
+	s = strings.TrimSpace(s)
+
+	for _, layout := range feedDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// filename builds "2006-01-02 Title.ext" from the entry. Without a title the
+// name of the remote file is used, without a date the prefix is left out.
+func (e feedEnclosure) filename() string {
+	u, _ := neturl.Parse(e.url)
+
+	var remote string
+	if u != nil && strings.Trim(u.Path, "/") != "" {
+		remote = path.Base(u.Path)
+	}
+
+	ext := path.Ext(remote)
+	if ext == "" && e.mimeType != "" {
+		if found := findExtension(e.mimeType); found != "unknown" {
+			ext = "." + found
+		}
+	}
+
+	name := sanitizeFilename(e.title)
+	if name == "" {
+		name = strings.TrimSuffix(sanitizeFilename(remote), ext)
+	}
+	if name == "" {
+		name = "episode"
+	}
+
+	if !e.published.IsZero() {
+		name = e.published.Format(feedDateFormat) + " " + name
+	}
+
+	return name + ext
+}
+
+// sanitizeFilename makes a title safe to use as a file name on all
+// platforms and caps its length.
+func sanitizeFilename(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r < ' ', r == 0x7f:
+			return ' '
+		case strings.ContainsRune(`/\:*?"<>|`, r):
+			return '_'
+		}
+
+		return r
+	}, s)
+
+	s = strings.Trim(strings.Join(strings.Fields(s), " "), ". ")
+
+	for len(s) > feedMaxTitleLen {
+		_, size := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-size]
+	}
+
+	return strings.TrimSpace(s)
+}
+
+// expandFeeds fetches every feed and returns the enclosure URLs to
+// download. Each URL gets a filename hint built from its entry.
+func (c *CLIApplication) expandFeeds(ctx context.Context) []string {
+	var urls []string
+
+	for _, feedURL := range c.feedOpts.urls {
+		enclosures, err := c.fetchFeed(ctx, feedURL)
+		if err != nil {
+			slog.Error("feed failed", logKeyURL, feedURL, logKeyError, err)
+
+			continue
+		}
+
+		if c.feedOpts.newest > 0 && len(enclosures) > c.feedOpts.newest {
+			enclosures = enclosures[:c.feedOpts.newest]
+		}
+
+		var queued int
+
+		for _, enc := range enclosures {
+			name := enc.filename()
+
+			if c.feedOpts.onlyNew {
+				if _, err := os.Stat(filepath.Join(c.outputDir, name)); err == nil {
+					slog.Debug("already downloaded", logKeyFile, name)
+
+					continue
+				}
+			}
+
+			c.setHint(enc.url, urlHint{filename: name})
+			urls = append(urls, enc.url)
+			queued++
+		}
+
+		slog.Info("feed parsed", logKeyURL, feedURL, "enclosures", len(enclosures), "queued", queued)
+	}
+
+	return urls
+}
+
+func (c *CLIApplication) fetchFeed(ctx context.Context, feedURL string) ([]feedEnclosure, error) {
+	base, err := neturl.Parse(feedURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidURL, err)
+	}
+
+	data, err := c.fetchBytes(ctx, feedURL, feedMaxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseFeed(data, base)
+}
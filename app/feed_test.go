@@ -0,0 +1,215 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testRSS = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+  <title>Podcast</title>
+  <item>
+    <title>Episode 1: Hello</title>
+    <pubDate>Mon, 06 May 2024 10:00:00 +0000</pubDate>
+    <enclosure url="/media/ep1.mp3" type="audio/mpeg" length="100"/>
+  </item>
+  <item>
+    <title>Episode 3 / Finale?</title>
+    <pubDate>Wed, 5 Jun 2024 10:00:00 GMT</pubDate>
+    <enclosure url="https://cdn.example.com/ep3.mp3?token=1" type="audio/mpeg"/>
+  </item>
+  <item>
+    <title>No media</title>
+  </item>
+  <item>
+    <title>Episode 2 &amp; more</title>
+    <pubDate>Mon, 20 May 2024 10:00:00 +0000</pubDate>
+    <enclosure url="/media/ep2.m4a" type="audio/mp4"/>
+  </item>
+</channel>
+</rss>`
+
+const testAtom = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Data releases</title>
+  <entry>
+    <title>Release A</title>
+    <updated>2024-01-02T00:00:00Z</updated>
+    <link rel="alternate" href="https://example.com/a.html"/>
+    <link rel="enclosure" href="https://example.com/a.tar.gz" type="application/gzip"/>
+  </entry>
+  <entry>
+    <title>Release B</title>
+    <published>2024-03-01T12:00:00+02:00</published>
+    <updated>2024-04-01T00:00:00Z</updated>
+    <link rel="enclosure" href="b.tar.gz"/>
+  </entry>
+</feed>`
+
+func TestParseFeedRSS(t *testing.T) {
+	base, _ := neturl.Parse("https://example.com/feed.xml")
+
+	enclosures, err := parseFeed([]byte(testRSS), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, enc := range enclosures {
+		got = append(got, enc.url+" "+enc.filename())
+	}
+
+	want := []string{
+		"https://cdn.example.com/ep3.mp3?token=1 2024-06-05 Episode 3 _ Finale_.mp3",
+		"https://example.com/media/ep2.m4a 2024-05-20 Episode 2 & more.m4a",
+		"https://example.com/media/ep1.mp3 2024-05-06 Episode 1_ Hello.mp3",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("enclosures =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestParseFeedAtom(t *testing.T) {
+	base, _ := neturl.Parse("https://example.com/releases/atom.xml")
+
+	enclosures, err := parseFeed([]byte(testAtom), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(enclosures) != 2 {
+		t.Fatalf("got %d enclosures, want 2", len(enclosures))
+	}
+
+	if enclosures[0].url != "https://example.com/releases/b.tar.gz" {
+		t.Errorf("url = %q", enclosures[0].url)
+	}
+	if name := enclosures[0].filename(); name != "2024-03-01 Release B.gz" {
+		t.Errorf("filename = %q", name)
+	}
+	if name := enclosures[1].filename(); name != "2024-01-02 Release A.gz" {
+		t.Errorf("filename = %q", name)
+	}
+}
+
+func TestParseFeedWithoutBase(t *testing.T) {
+	tests := []struct {
+		name string
+		feed string
+		want []string
+	}{
+		{
+			name: "rss channel link",
+			feed: `<rss><channel><link>https://pod.example.com/show/</link>
+				<atom:link href="" rel="self" xmlns:atom="http://www.w3.org/2005/Atom"/>
+				<item><enclosure url="media/ep1.mp3"/></item></channel></rss>`,
+			want: []string{"https://pod.example.com/show/media/ep1.mp3"},
+		},
+		{
+			name: "atom self link and xml:base",
+			feed: `<feed xmlns="http://www.w3.org/2005/Atom" xml:base="/files/">
+				<link rel="self" href="https://data.example.com/feed.atom"/>
+				<entry><link rel="enclosure" href="b.tar.gz"/></entry></feed>`,
+			want: []string{"https://data.example.com/files/b.tar.gz"},
+		},
+		{
+			name: "no base",
+			feed: `<rss><channel><item><enclosure url="/media/ep1.mp3"/></item>
+				<item><enclosure url="https://cdn.example.com/ep2.mp3"/></item></channel></rss>`,
+			want: []string{"https://cdn.example.com/ep2.mp3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enclosures, err := parseFeed([]byte(tt.feed), &neturl.URL{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, enc := range enclosures {
+				got = append(got, enc.url)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("enclosures = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFeedInvalid(t *testing.T) {
+	base, _ := neturl.Parse("https://example.com/")
+
+	if _, err := parseFeed([]byte("not a feed <"), base); err == nil {
+		t.Error("expected an error for a broken document")
+	}
+}
+
+func TestFeedEnclosureFilenameFallback(t *testing.T) {
+	tests := []struct {
+		enc  feedEnclosure
+		want string
+	}{
+		{feedEnclosure{url: "https://example.com/show/ep9.mp3"}, "ep9.mp3"},
+		{feedEnclosure{url: "https://example.com/stream", mimeType: "video/mp4"}, "stream.mp4"},
+		{feedEnclosure{url: "https://example.com/", title: " .. "}, "episode"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.enc.filename(); got != tt.want {
+			t.Errorf("filename(%q) = %q, want %q", tt.enc.url, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	if got := sanitizeFilename("  a\tb:\"c\"  "); got != "a b__c_" {
+		t.Errorf("sanitizeFilename = %q", got)
+	}
+
+	long := sanitizeFilename(strings.Repeat("é", feedMaxTitleLen))
+	if len(long) > feedMaxTitleLen || !strings.HasSuffix(long, "é") {
+		t.Errorf("sanitizeFilename cut a rune or kept too much: %d bytes", len(long))
+	}
+}
+
+func TestExpandFeeds(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		_, _ = w.Write([]byte(testRSS))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "2024-06-05 Episode 3 _ Finale_.mp3"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	app := &CLIApplication{
+		Client:    ts.Client(),
+		outputDir: dir,
+		feedOpts:  &feedOptions{urls: []string{ts.URL + "/feed.xml"}, newest: 2, onlyNew: true},
+	}
+
+	got := app.expandFeeds(context.Background())
+	want := []string{ts.URL + "/media/ep2.m4a"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expandFeeds = %v, want %v", got, want)
+	}
+
+	if name := app.hint(want[0]).filename; name != "2024-05-20 Episode 2 & more.m4a" {
+		t.Errorf("filename hint = %q", name)
+	}
+}
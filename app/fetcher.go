@@ -0,0 +1,75 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	neturl "net/url"
+	"time"
+)
+
+var errUnsupportedScheme = errors.New("unsupported url scheme")
+
+// fetcher is a protocol backend. Chunking, resume and progress are handled
+// by the download pipeline; a fetcher only knows how to talk to the server.
+type fetcher interface {
+	// probe returns metadata of the remote file without downloading it.
+	probe(ctx context.Context, url string) (*remoteInfo, error)
+
+	// openRange opens the inclusive byte range start-end of the remote file.
+	openRange(ctx context.Context, url string, start, end int64) (io.ReadCloser, error)
+
+	// openStream opens the remote file from offset. The returned offset is
+	// where the stream really starts, 0 if the server can't seek.
+	openStream(ctx context.Context, url string, offset int64) (io.ReadCloser, int64, error)
+}
+
+// remoteInfo is what a probe tells about a remote file.
+type remoteInfo struct {
+	modTime     time.Time
+	filename    string
+	contentType string
+	length      int64
+	ranges      bool
+}
+
+type fetcherFactory func(c *CLIApplication) fetcher
+
+// fetchers maps URL schemes to their backends.
+var fetchers = map[string]fetcherFactory{
+	"http":  newHTTPFetcher,
+	"https": newHTTPFetcher,
+	"ftp":   newFTPFetcher,
+	"ftpes": newFTPSFetcher,
+	"s3":    newS3Fetcher,
+}
+
+func supportedScheme(scheme string) bool {
+	_, ok := fetchers[scheme]
+
+	return ok
+}
+
+func (c *CLIApplication) fetcherFor(url string) (fetcher, error) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", errInvalidURL.Error(), err)
+	}
+
+	factory, ok := fetchers[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errUnsupportedScheme, u.Scheme)
+	}
+
+	f := factory(c)
+	if c.conns != nil {
+		f = &limitedFetcher{fetcher: f, conns: c.conns}
+	}
+
+	if c.pacer != nil {
+		f = &pacedFetcher{fetcher: f, pacer: c.pacer}
+	}
+
+	return f, nil
+}
@@ -0,0 +1,398 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errFTPPassive = errors.New("ftp passive mode failed")
+	errFTPPath    = errors.New("invalid ftp path")
+)
+
+const (
+	ftpDefaultPort    = "21"
+	ftpAnonymousUser  = "anonymous"
+	ftpAnonymousPass  = "anonymous@"
+	ftpModTimeLayout  = "20060102150405"
+	ftpCodeFileStatus = 213
+)
+
+// ftpFetcher is the fetcher for ftp URLs. With tlsConfig set it speaks
+// explicit FTPS (AUTH TLS on the plain control port, protected data channel).
+type ftpFetcher struct {
+	dialer    contextDialer
+	tlsConfig *tls.Config
+}
+
+func appDialer(c *CLIApplication) contextDialer {
+	if c == nil || c.dialer == nil {
+		return newDialer()
+	}
+
+	return c.dialer
+}
+
+func newFTPFetcher(c *CLIApplication) fetcher {
+	return &ftpFetcher{dialer: appDialer(c)}
+}
+
+func newFTPSFetcher(c *CLIApplication) fetcher {
+	return &ftpFetcher{
+		dialer: appDialer(c),
+		tlsConfig: &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			ClientSessionCache: tls.NewLRUClientSessionCache(0),
+		},
+	}
+}
+
+// ftpConn is a logged in control connection.
+type ftpConn struct {
+	conn      net.Conn
+	text      *textproto.Conn
+	tlsConfig *tls.Config
+	stop      func() bool
+}
+
+func (f *ftpFetcher) connect(ctx context.Context, u *neturl.URL) (*ftpConn, error) {
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), ftpDefaultPort)
+	}
+
+	conn, err := f.dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	fc := &ftpConn{
+		conn: conn,
+		text: textproto.NewConn(conn),
+	}
+
+	// closing the control connection unblocks every pending read
+	fc.stop = context.AfterFunc(ctx, func() { _ = conn.Close() })
+
+	if err := fc.login(u, f.tlsConfig); err != nil {
+		fc.close()
+
+		return nil, err
+	}
+
+	return fc, nil
+}
+
+func (fc *ftpConn) login(u *neturl.URL, tlsConfig *tls.Config) error {
+	if _, _, err := fc.text.ReadResponse(220); err != nil {
+		return fmt.Errorf("ftp greeting failed: %w", err)
+	}
+
+	if tlsConfig != nil {
+		if _, err := fc.cmd(234, "AUTH TLS"); err != nil {
+			return err
+		}
+
+		cfg := tlsConfig.Clone()
+		cfg.ServerName = u.Hostname()
+		fc.tlsConfig = cfg
+		fc.conn = tls.Client(fc.conn, cfg)
+		fc.text = textproto.NewConn(fc.conn)
+
+		if _, err := fc.cmd(200, "PBSZ 0"); err != nil {
+			return err
+		}
+		if _, err := fc.cmd(200, "PROT P"); err != nil {
+			return err
+		}
+	}
+
+	user, pass := ftpAnonymousUser, ftpAnonymousPass
+	if u.User != nil {
+		user = u.User.Username()
+		if p, ok := u.User.Password(); ok {
+			pass = p
+		}
+	}
+
+	code, err := fc.cmd(0, "USER %s", user)
+	if err != nil {
+		return err
+	}
+
+	if code == 331 {
+		if _, err := fc.cmd(230, "PASS %s", pass); err != nil {
+			return err
+		}
+	} else if code != 230 {
+		return fmt.Errorf("ftp login failed: %d", code)
+	}
+
+	if _, err := fc.cmd(200, "TYPE I"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// cmd sends a command and reads its reply. expect 0 accepts any code.
+func (fc *ftpConn) cmd(expect int, format string, args ...any) (int, error) {
+	code, _, err := fc.cmdMessage(expect, format, args...)
+
+	return code, err
+}
+
+func (fc *ftpConn) cmdMessage(expect int, format string, args ...any) (int, string, error) {
+	id, err := fc.text.Cmd(format, args...)
+	if err != nil {
+		return 0, "", fmt.Errorf("ftp command failed: %w", err)
+	}
+
+	fc.text.StartResponse(id)
+	defer fc.text.EndResponse(id)
+
+	code, msg, err := fc.text.ReadResponse(expect)
+	if err != nil {
+		return code, msg, fmt.Errorf("ftp %s: %w", strings.Fields(format)[0], err)
+	}
+
+	return code, msg, nil
+}
+
+// openData opens a passive mode data connection, preferring EPSV.
+func (fc *ftpConn) openData(ctx context.Context) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(fc.conn.RemoteAddr().String())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errFTPPassive, err)
+	}
+
+	port, err := fc.passivePort()
+	if err != nil {
+		return nil, err
+	}
+
+	// servers may refuse data connections from another address than the
+	// control connection, so bind to the same local address
+	nd := net.Dialer{Timeout: dialTimeout}
+	if local, ok := fc.conn.LocalAddr().(*net.TCPAddr); ok {
+		nd.LocalAddr = &net.TCPAddr{IP: local.IP}
+	}
+
+	conn, err := nd.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errFTPPassive, err)
+	}
+
+	if fc.tlsConfig != nil {
+		conn = tls.Client(conn, fc.tlsConfig)
+	}
+
+	return conn, nil
+}
+
+func (fc *ftpConn) passivePort() (int, error) {
+	if _, msg, err := fc.cmdMessage(229, "EPSV"); err == nil {
+		// 229 Entering Extended Passive Mode (|||port|)
+		start := strings.Index(msg, "(")
+		end := strings.LastIndex(msg, ")")
+		if start >= 0 && end > start {
+			fields := strings.Split(msg[start+1:end], "|")
+			if len(fields) == 5 {
+				if port, err := strconv.Atoi(fields[3]); err == nil {
+					return port, nil
+				}
+			}
+		}
+	}
+
+	_, msg, err := fc.cmdMessage(227, "PASV")
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", errFTPPassive, err)
+	}
+
+	// 227 Entering Passive Mode (h1,h2,h3,h4,p1,p2); the host part is
+	// ignored, servers behind NAT often announce a private address.
+	start := strings.Index(msg, "(")
+	end := strings.LastIndex(msg, ")")
+	if start < 0 || end <= start {
+		return 0, fmt.Errorf("%w: %s", errFTPPassive, msg)
+	}
+
+	fields := strings.Split(msg[start+1:end], ",")
+	if len(fields) != 6 {
+		return 0, fmt.Errorf("%w: %s", errFTPPassive, msg)
+	}
+
+	p1, err1 := strconv.Atoi(strings.TrimSpace(fields[4]))
+	p2, err2 := strconv.Atoi(strings.TrimSpace(fields[5]))
+	if err1 != nil || err2 != nil {
+		return 0, fmt.Errorf("%w: %s", errFTPPassive, msg)
+	}
+
+	return p1<<8 | p2, nil
+}
+
+// retr starts a transfer of path from offset using REST.
+func (fc *ftpConn) retr(ctx context.Context, path string, offset int64) (net.Conn, error) {
+	if offset > 0 {
+		if _, err := fc.cmd(350, "REST %d", offset); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := fc.openData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := fc.cmd(1, "RETR %s", path); err != nil {
+		_ = data.Close()
+
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (fc *ftpConn) close() {
+	fc.stop()
+	_ = fc.text.PrintfLine("QUIT")
+	_ = fc.conn.Close()
+}
+
+// ftpReader streams a RETR data connection and tears down both connections
+// on Close.
+type ftpReader struct {
+	reader io.Reader
+	data   net.Conn
+	ctrl   *ftpConn
+	stop   func() bool
+}
+
+func (r *ftpReader) Read(p []byte) (int, error) {
+	return r.reader.Read(p)
+}
+
+func (r *ftpReader) Close() error {
+	r.stop()
+	err := r.data.Close()
+	r.ctrl.close()
+
+	return err
+}
+
+// parseFTPURL parses an ftp URL. The path and credentials are decoded and
+// go into commands as they are, so a %0d%0a in them would end the command
+// and smuggle in another one; those are refused.
+func parseFTPURL(url string) (*neturl.URL, error) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", errInvalidURL.Error(), err)
+	}
+
+	fields := []string{u.Path}
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		fields = append(fields, u.User.Username(), pass)
+	}
+
+	for _, field := range fields {
+		if strings.ContainsAny(field, "\r\n\x00") {
+			return nil, fmt.Errorf("%w: %q", errFTPPath, field)
+		}
+	}
+
+	return u, nil
+}
+
+func (f *ftpFetcher) probe(ctx context.Context, url string) (*remoteInfo, error) {
+	u, err := parseFTPURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	fc, err := f.connect(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	defer fc.close()
+
+	info := &remoteInfo{length: -1}
+
+	if _, msg, err := fc.cmdMessage(ftpCodeFileStatus, "SIZE %s", u.Path); err == nil {
+		if size, err := strconv.ParseInt(strings.TrimSpace(msg), 10, bitSize64); err == nil {
+			info.length = size
+			info.ranges = true
+		}
+	}
+
+	if _, msg, err := fc.cmdMessage(ftpCodeFileStatus, "MDTM %s", u.Path); err == nil {
+		if t, err := time.Parse(ftpModTimeLayout, strings.TrimSpace(msg)); err == nil {
+			info.modTime = t
+		}
+	}
+
+	return info, nil
+}
+
+func (f *ftpFetcher) openRange(ctx context.Context, url string, start, end int64) (io.ReadCloser, error) {
+	body, offset, err := f.open(ctx, url, start)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset != start {
+		_ = body.Close()
+
+		return nil, fmt.Errorf("chunk fetch failed: server does not support REST %d", start)
+	}
+
+	body.reader = io.LimitReader(body.data, end-start+1)
+
+	return body, nil
+}
+
+func (f *ftpFetcher) openStream(ctx context.Context, url string, offset int64) (io.ReadCloser, int64, error) {
+	return f.open(ctx, url, offset)
+}
+
+func (f *ftpFetcher) open(ctx context.Context, url string, offset int64) (*ftpReader, int64, error) {
+	u, err := parseFTPURL(url)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	fc, err := f.connect(ctx, u)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	data, err := fc.retr(ctx, u.Path, offset)
+	if err != nil && offset > 0 {
+		// server can't seek, start over
+		offset = 0
+		data, err = fc.retr(ctx, u.Path, 0)
+	}
+
+	if err != nil {
+		fc.close()
+
+		return nil, 0, err
+	}
+
+	return &ftpReader{
+		reader: data,
+		data:   data,
+		ctrl:   fc,
+		stop:   context.AfterFunc(ctx, func() { _ = data.Close() }),
+	}, offset, nil
+}
@@ -0,0 +1,320 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testFTPServer is a minimal in-process FTP server: anonymous login,
+// passive mode, SIZE/MDTM/REST/RETR.
+type testFTPServer struct {
+	listener net.Listener
+	files    map[string][]byte
+	noEPSV   bool
+	noREST   bool
+	logins   atomic.Int32
+	deletes  atomic.Int32
+}
+
+func newTestFTPServer(t *testing.T, files map[string][]byte, opts ...func(*testFTPServer)) *testFTPServer {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testFTPServer{listener: ln, files: files}
+	for _, opt := range opts {
+		opt(s)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *testFTPServer) url(path string) string {
+	return "ftp://" + s.listener.Addr().String() + path
+}
+
+func (s *testFTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reply := func(format string, args ...any) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+
+	var (
+		offset int64
+		dataLn net.Listener
+	)
+	defer func() {
+		if dataLn != nil {
+			dataLn.Close()
+		}
+	}()
+
+	reply("220 test server ready")
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		cmd, arg, _ := strings.Cut(scanner.Text(), " ")
+
+		switch strings.ToUpper(cmd) {
+		case "USER":
+			reply("331 password please")
+		case "PASS":
+			s.logins.Add(1)
+			reply("230 logged in")
+		case "TYPE":
+			reply("200 binary")
+		case "SIZE":
+			data, ok := s.files[arg]
+			if !ok {
+				reply("550 no such file")
+
+				continue
+			}
+			reply("213 %d", len(data))
+		case "MDTM":
+			reply("213 20240102030405")
+		case "EPSV", "PASV":
+			if s.noEPSV && cmd == "EPSV" {
+				reply("502 not implemented")
+
+				continue
+			}
+
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				reply("425 cannot open data connection")
+
+				continue
+			}
+			dataLn = ln
+
+			port := ln.Addr().(*net.TCPAddr).Port
+			if cmd == "EPSV" {
+				reply("229 Entering Extended Passive Mode (|||%d|)", port)
+			} else {
+				reply("227 Entering Passive Mode (10,0,0,1,%d,%d)", port>>8, port&0xff)
+			}
+		case "REST":
+			if s.noREST {
+				reply("502 not implemented")
+
+				continue
+			}
+			offset, _ = strconv.ParseInt(arg, 10, 64)
+			reply("350 restarting")
+		case "RETR":
+			data, ok := s.files[arg]
+			if !ok || dataLn == nil {
+				reply("550 no such file")
+
+				continue
+			}
+
+			reply("150 opening data connection")
+
+			dc, err := dataLn.Accept()
+			if err != nil {
+				continue
+			}
+			dc.Write(data[offset:])
+			dc.Close()
+			dataLn.Close()
+			dataLn = nil
+			offset = 0
+
+			reply("226 transfer complete")
+		case "DELE":
+			s.deletes.Add(1)
+			reply("250 deleted")
+		case "QUIT":
+			reply("221 bye")
+
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestFTPFetcherProbe(t *testing.T) {
+	content := []byte("ftp probe content")
+	s := newTestFTPServer(t, map[string][]byte{"/pub/file.bin": content})
+
+	f := newFTPFetcher(nil)
+
+	info, err := f.probe(context.Background(), s.url("/pub/file.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.length != int64(len(content)) {
+		t.Errorf("length = %d, want %d", info.length, len(content))
+	}
+	if !info.ranges {
+		t.Error("expected ranges to be true")
+	}
+
+	want := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if !info.modTime.Equal(want) {
+		t.Errorf("modTime = %v, want %v", info.modTime, want)
+	}
+}
+
+func TestFTPFetcherProbeMissingFile(t *testing.T) {
+	s := newTestFTPServer(t, map[string][]byte{})
+
+	info, err := newFTPFetcher(nil).probe(context.Background(), s.url("/missing.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.length != -1 || info.ranges {
+		t.Errorf("expected unknown length without ranges, got %d / %v", info.length, info.ranges)
+	}
+}
+
+func TestFTPFetcherRefusesCommandsInPath(t *testing.T) {
+	s := newTestFTPServer(t, map[string][]byte{"/a": []byte("data")})
+	f := newFTPFetcher(nil)
+	ctx := context.Background()
+
+	for _, url := range []string{s.url("/a%0d%0aDELE%20b"), s.url("/a%00"), "ftp://x%0aDELE%20b@" + s.listener.Addr().String() + "/a"} {
+		if _, err := f.probe(ctx, url); !errors.Is(err, errFTPPath) {
+			t.Errorf("probe(%s) error = %v, want %v", url, err, errFTPPath)
+		}
+		if _, _, err := f.openStream(ctx, url, 0); !errors.Is(err, errFTPPath) {
+			t.Errorf("openStream(%s) error = %v, want %v", url, err, errFTPPath)
+		}
+		if _, err := f.openRange(ctx, url, 0, 1); !errors.Is(err, errFTPPath) {
+			t.Errorf("openRange(%s) error = %v, want %v", url, err, errFTPPath)
+		}
+	}
+
+	if n := s.deletes.Load(); n != 0 {
+		t.Errorf("server got %d DELE command(s), want none", n)
+	}
+	if n := s.logins.Load(); n != 0 {
+		t.Errorf("server got %d login(s), the url should be refused before connecting", n)
+	}
+}
+
+func TestFTPFetcherOpenRange(t *testing.T) {
+	s := newTestFTPServer(t, map[string][]byte{"/file.bin": []byte("0123456789")})
+
+	body, err := newFTPFetcher(nil).openRange(context.Background(), s.url("/file.bin"), 3, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+
+	got, _ := io.ReadAll(body)
+	if string(got) != "3456" {
+		t.Errorf("openRange read %q, want '3456'", got)
+	}
+}
+
+func TestFTPFetcherOpenRangeNoREST(t *testing.T) {
+	s := newTestFTPServer(t, map[string][]byte{"/file.bin": []byte("0123456789")}, func(s *testFTPServer) {
+		s.noREST = true
+	})
+
+	_, err := newFTPFetcher(nil).openRange(context.Background(), s.url("/file.bin"), 3, 6)
+	if err == nil {
+		t.Error("expected error when server does not support REST")
+	}
+}
+
+func TestFTPFetcherOpenStreamPASV(t *testing.T) {
+	s := newTestFTPServer(t, map[string][]byte{"/file.bin": []byte("0123456789")}, func(s *testFTPServer) {
+		s.noEPSV = true
+	})
+
+	body, offset, err := newFTPFetcher(nil).openStream(context.Background(), s.url("/file.bin"), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+
+	if offset != 4 {
+		t.Errorf("offset = %d, want 4", offset)
+	}
+
+	got, _ := io.ReadAll(body)
+	if string(got) != "456789" {
+		t.Errorf("openStream read %q, want '456789'", got)
+	}
+}
+
+func TestFTPDownloadChunked(t *testing.T) {
+	content := []byte(strings.Repeat("ftp segmented download ", 50))
+	s := newTestFTPServer(t, map[string][]byte{"/data/archive.bin": content})
+
+	dir := t.TempDir()
+	app := &CLIApplication{
+		chunkSize: 4,
+		limiter:   newRateLimiter(0),
+		outputDir: dir,
+	}
+
+	r, err := app.getResourceInformation(context.Background(), s.url("/data/archive.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.chunks) != 4 {
+		t.Fatalf("chunks = %d, want 4", len(r.chunks))
+	}
+
+	pd := newProgressDisplay()
+	done := make(chan downloadResult, 1)
+	go app.download(context.Background(), r, done, pd)
+
+	if result := <-done; !result.ok {
+		t.Fatal("expected ftp download to succeed")
+	}
+
+	outputPath := filepath.Join(dir, "archive.bin")
+	got, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(content) {
+		t.Errorf("downloaded %d bytes, want %d", len(got), len(content))
+	}
+
+	// probe plus one control connection per segment
+	if logins := s.logins.Load(); logins != 5 {
+		t.Errorf("logins = %d, want 5", logins)
+	}
+
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ModTime().Year() != 2024 {
+		t.Errorf("modification time = %v, want MDTM value", info.ModTime())
+	}
+}
@@ -0,0 +1,126 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+)
+
+// httpFetcher is the fetcher for http and https URLs.
+type httpFetcher struct {
+	client *http.Client
+}
+
+func newHTTPFetcher(c *CLIApplication) fetcher {
+	return &httpFetcher{client: c.Client}
+}
+
+func (h *httpFetcher) probe(ctx context.Context, url string) (*remoteInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, newTooManyRequestsError(resp)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: returned %d", errHTTPStatusIsNotOK, resp.StatusCode)
+	}
+
+	info := &remoteInfo{length: resp.ContentLength}
+
+	acceptRanges, ok := resp.Header["Accept-Ranges"]
+	if ok && len(acceptRanges) > 0 && acceptRanges[0] == "bytes" {
+		info.ranges = true
+	}
+
+	if ct, ok := resp.Header["Content-Type"]; ok {
+		info.contentType = ct[0]
+	}
+
+	if cd, ok := resp.Header["Content-Disposition"]; ok {
+		_, params, err := mime.ParseMediaType(cd[0])
+		if err == nil {
+			name := filepath.Base(params["filename"])
+			if name != "." && name != "/" {
+				info.filename = name
+			}
+		}
+	}
+
+	return info, nil
+}
+
+func (h *httpFetcher) openRange(ctx context.Context, url string, start, end int64) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		_ = resp.Body.Close()
+
+		return nil, newTooManyRequestsError(resp)
+	}
+
+	if resp.StatusCode != http.StatusPartialContent {
+		_ = resp.Body.Close()
+
+		return nil, fmt.Errorf("chunk fetch failed: expected 206, got %d", resp.StatusCode)
+	}
+
+	return resp.Body, nil
+}
+
+func (h *httpFetcher) openStream(ctx context.Context, url string, offset int64) (io.ReadCloser, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		_ = resp.Body.Close()
+
+		return nil, 0, newTooManyRequestsError(resp)
+	}
+
+	// reject non-success responses
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		_ = resp.Body.Close()
+
+		return nil, 0, fmt.Errorf("%w: returned %d", errHTTPStatusIsNotOK, resp.StatusCode)
+	}
+
+	// server didn't honor Range request, stream starts from scratch
+	if resp.StatusCode != http.StatusPartialContent {
+		offset = 0
+	}
+
+	return resp.Body, offset, nil
+}
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPFetcherProbe(t *testing.T) {
+	content := []byte("probe me")
+	ts := newTestServer(content, true)
+	defer ts.Close()
+
+	f := &httpFetcher{client: ts.Client()}
+
+	info, err := f.probe(context.Background(), ts.URL+"/file.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.length != int64(len(content)) {
+		t.Errorf("length = %d, want %d", info.length, len(content))
+	}
+	if !info.ranges {
+		t.Error("expected ranges to be true")
+	}
+	if info.contentType != "application/octet-stream" {
+		t.Errorf("contentType = %q", info.contentType)
+	}
+}
+
+func TestHTTPFetcherOpenRange(t *testing.T) {
+	ts := newTestServer([]byte("0123456789"), true)
+	defer ts.Close()
+
+	f := &httpFetcher{client: ts.Client()}
+
+	body, err := f.openRange(context.Background(), ts.URL+"/file.bin", 2, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+
+	got, _ := io.ReadAll(body)
+	if string(got) != "2345" {
+		t.Errorf("openRange read %q, want '2345'", got)
+	}
+}
+
+func TestHTTPFetcherOpenStreamRangeIgnored(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("full body"))
+	}))
+	defer ts.Close()
+
+	f := &httpFetcher{client: ts.Client()}
+
+	body, offset, err := f.openStream(context.Background(), ts.URL+"/file.bin", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+
+	if offset != 0 {
+		t.Errorf("offset = %d, want 0 when server ignores Range", offset)
+	}
+}
+
+func TestHTTPFetcherOpenStreamResume(t *testing.T) {
+	ts := newTestServer([]byte("0123456789"), true)
+	defer ts.Close()
+
+	f := &httpFetcher{client: ts.Client()}
+
+	body, offset, err := f.openStream(context.Background(), ts.URL+"/file.bin", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+
+	if offset != 6 {
+		t.Errorf("offset = %d, want 6", offset)
+	}
+
+	got, _ := io.ReadAll(body)
+	if string(got) != "6789" {
+		t.Errorf("openStream read %q, want '6789'", got)
+	}
+}
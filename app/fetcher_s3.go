@@ -0,0 +1,382 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errInvalidS3URL = errors.New("invalid s3 url")
+
+const (
+	s3DefaultRegion  = "us-east-1"
+	s3DefaultProfile = "default"
+	s3Service        = "s3"
+	sigV4Algorithm   = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat  = "20060102T150405Z"
+	sigV4DateLen     = 8
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+type awsCredentials struct {
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+// s3Config holds the settings shared by all s3:// downloads. Credentials
+// are read once, from the environment or else from the shared
+// credentials file.
+type s3Config struct {
+	creds    *awsCredentials
+	endpoint string
+	region   string
+	profile  string
+	once     sync.Once
+}
+
+func newS3ConfigFromEnv() *s3Config {
+	cfg := &s3Config{
+		endpoint: firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"),
+		region:   firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+		profile:  firstEnv("AWS_PROFILE"),
+	}
+
+	if cfg.region == "" {
+		cfg.region = s3DefaultRegion
+	}
+	if cfg.profile == "" {
+		cfg.profile = s3DefaultProfile
+	}
+
+	return cfg
+}
+
+func firstEnv(keys ...string) string {
+	for _, key := range keys {
+		if v := os.Getenv(key); v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+func (cfg *s3Config) credentials() *awsCredentials {
+	cfg.once.Do(func() {
+		if cfg.creds != nil {
+			return
+		}
+
+		if key := os.Getenv("AWS_ACCESS_KEY_ID"); key != "" {
+			cfg.creds = &awsCredentials{
+				accessKey:    key,
+				secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+				sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+			}
+
+			return
+		}
+
+		path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+		if path == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return
+			}
+			path = filepath.Join(home, ".aws", "credentials")
+		}
+
+		cfg.creds = readCredentialsFile(path, cfg.profile)
+	})
+
+	return cfg.creds
+}
+
+// readCredentialsFile reads a profile of an AWS shared credentials file.
+// It returns nil if the file or the profile doesn't exist.
+func readCredentialsFile(path, profile string) *awsCredentials {
+	f, err := os.Open(path) // #nosec G304 -- user's own credentials file
+	if err != nil {
+		return nil
+	}
+	defer func() { _ = f.Close() }()
+
+	var (
+		creds   awsCredentials
+		section string
+	)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+
+			continue
+		}
+
+		if section != profile {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+
+		switch strings.TrimSpace(key) {
+		case "aws_access_key_id":
+			creds.accessKey = strings.TrimSpace(value)
+		case "aws_secret_access_key":
+			creds.secretKey = strings.TrimSpace(value)
+		case "aws_session_token":
+			creds.sessionToken = strings.TrimSpace(value)
+		}
+	}
+
+	if creds.accessKey == "" {
+		return nil
+	}
+
+	return &creds
+}
+
+// s3Fetcher downloads s3://bucket/key URLs over HTTPS. Requests are signed
+// with Signature Version 4 when credentials are available, otherwise they
+// are sent anonymously for public buckets.
+type s3Fetcher struct {
+	http   *httpFetcher
+	config *s3Config
+}
+
+func newS3Fetcher(c *CLIApplication) fetcher {
+	cfg := c.s3
+	if cfg == nil {
+		cfg = newS3ConfigFromEnv()
+	}
+
+	var base http.RoundTripper
+	if c.Client != nil {
+		base = c.Client.Transport
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	client := &http.Client{Transport: &sigV4Transport{base: base, config: cfg}}
+
+	return &s3Fetcher{http: &httpFetcher{client: client}, config: cfg}
+}
+
+// objectURL maps s3://bucket/key to the HTTPS URL of the object. A custom
+// endpoint (MinIO and friends) uses path-style addressing, AWS uses
+// virtual-hosted style unless the bucket name has dots.
+func (s *s3Fetcher) objectURL(url string) (string, error) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errInvalidS3URL, err)
+	}
+
+	bucket := u.Host
+	key := strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return "", fmt.Errorf("%w: want s3://bucket/key, got %s", errInvalidS3URL, url)
+	}
+
+	if s.config.endpoint != "" {
+		endpoint, err := neturl.Parse(s.config.endpoint)
+		if err != nil || endpoint.Host == "" {
+			return "", fmt.Errorf("%w: bad endpoint %q", errInvalidS3URL, s.config.endpoint)
+		}
+
+		endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + "/" + bucket + "/" + key
+
+		return endpoint.String(), nil
+	}
+
+	host := "s3." + s.config.region + ".amazonaws.com"
+	if strings.Contains(bucket, ".") {
+		return (&neturl.URL{Scheme: "https", Host: host, Path: "/" + bucket + "/" + key}).String(), nil
+	}
+
+	return (&neturl.URL{Scheme: "https", Host: bucket + "." + host, Path: "/" + key}).String(), nil
+}
+
+func (s *s3Fetcher) probe(ctx context.Context, url string) (*remoteInfo, error) {
+	target, err := s.objectURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.http.probe(ctx, target)
+}
+
+func (s *s3Fetcher) openRange(ctx context.Context, url string, start, end int64) (io.ReadCloser, error) {
+	target, err := s.objectURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.http.openRange(ctx, target, start, end)
+}
+
+func (s *s3Fetcher) openStream(ctx context.Context, url string, offset int64) (io.ReadCloser, int64, error) {
+	target, err := s.objectURL(url)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return s.http.openStream(ctx, target, offset)
+}
+
+// sigV4Transport signs every request before passing it on.
+type sigV4Transport struct {
+	base   http.RoundTripper
+	config *s3Config
+}
+
+func (t *sigV4Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	creds := t.config.credentials()
+	if creds == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	signed := req.Clone(req.Context())
+	signRequest(signed, creds, t.config.region, time.Now())
+
+	return t.base.RoundTrip(signed)
+}
+
+// signRequest adds AWS Signature Version 4 headers to a request without
+// a body.
+func signRequest(req *http.Request, creds *awsCredentials, region string, t time.Time) {
+	amzDate := t.UTC().Format(sigV4TimeFormat)
+	date := amzDate[:sigV4DateLen]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "range" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalURI := awsURIEncode(req.URL.Path, false)
+	if canonicalURI == "" {
+		canonicalURI = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + s3Service + "/aws4_request"
+	stringToSign := strings.Join([]string{sigV4Algorithm, amzDate, scope, sha256Hex(canonicalRequest)}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, s3Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, creds.accessKey, scope, signedHeaders, signature,
+	))
+}
+
+func canonicalQuery(values neturl.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string{}, values[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, awsURIEncode(k, true)+"="+awsURIEncode(v, true))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+// awsURIEncode percent-encodes everything but RFC 3986 unreserved
+// characters, as SigV4 requires.
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+
+	for i := range len(s) {
+		ch := s[i]
+
+		switch {
+		case ch >= 'A' && ch <= 'Z', ch >= 'a' && ch <= 'z', ch >= '0' && ch <= '9',
+			ch == '-', ch == '_', ch == '.', ch == '~':
+			b.WriteByte(ch)
+		case ch == '/' && !encodeSlash:
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+
+	return b.String()
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+
+	return h.Sum(nil)
+}
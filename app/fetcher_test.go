@@ -0,0 +1,38 @@
+package app
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFetcherFor(t *testing.T) {
+	app := &CLIApplication{}
+
+	for _, url := range []string{"http://example.com/a", "https://example.com/b"} {
+		f, err := app.fetcherFor(url)
+		if err != nil {
+			t.Fatalf("fetcherFor(%q) error = %v", url, err)
+		}
+		if _, ok := f.(*httpFetcher); !ok {
+			t.Errorf("fetcherFor(%q) = %T, want *httpFetcher", url, f)
+		}
+	}
+}
+
+func TestFetcherForUnsupportedScheme(t *testing.T) {
+	app := &CLIApplication{}
+
+	_, err := app.fetcherFor("gopher://example.com/file")
+	if !errors.Is(err, errUnsupportedScheme) {
+		t.Errorf("expected errUnsupportedScheme, got %v", err)
+	}
+}
+
+func TestSupportedScheme(t *testing.T) {
+	if !supportedScheme("https") {
+		t.Error("https should be supported")
+	}
+	if supportedScheme("gopher") {
+		t.Error("gopher should not be supported")
+	}
+}
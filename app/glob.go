@@ -0,0 +1,280 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	errInvalidGlob  = errors.New("invalid url pattern")
+	errGlobTooLarge = errors.New("url pattern expands to too many urls")
+)
+
+const globMaxURLs = 100_000
+
+var (
+	globNumericRange = regexp.MustCompile(`^(\d+)-(\d+)(?::(\d+))?$`)
+	globLetterRange  = regexp.MustCompile(`^([a-zA-Z])-([a-zA-Z])(?::(\d+))?$`)
+	globReference    = regexp.MustCompile(`#(\d+)`)
+)
+
+// globExpansion is one URL produced by a pattern, with the values picked
+// for each of its globs in order.
+type globExpansion struct {
+	url    string
+	values []string
+}
+
+// globPart is a literal piece of a pattern, or a glob with its values.
+type globPart struct {
+	literal string
+	values  []string
+}
+
+// expandGlob expands curl style URL patterns: [001-250] and [a-z] ranges,
+// optionally stepped as [0-100:10], and {x86,arm64} alternations. The
+// leftmost glob varies slowest. Brackets that don't hold a range, such as
+// an IPv6 host, are kept as they are; a backslash escapes [ ] { and }.
+func expandGlob(pattern string) ([]globExpansion, error) {
+	parts, err := parseGlob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	total := 1
+
+	for _, part := range parts {
+		if part.values == nil {
+			continue
+		}
+
+		total *= len(part.values)
+		if total > globMaxURLs {
+			return nil, fmt.Errorf("%w: more than %d", errGlobTooLarge, globMaxURLs)
+		}
+	}
+
+	expansions := []globExpansion{{}}
+
+	for _, part := range parts {
+		if part.values == nil {
+			for i := range expansions {
+				expansions[i].url += part.literal
+			}
+
+			continue
+		}
+
+		next := make([]globExpansion, 0, len(expansions)*len(part.values))
+
+		for _, e := range expansions {
+			for _, v := range part.values {
+				values := append(append([]string{}, e.values...), v)
+				next = append(next, globExpansion{url: e.url + v, values: values})
+			}
+		}
+
+		expansions = next
+	}
+
+	return expansions, nil
+}
+
+func parseGlob(pattern string) ([]globPart, error) {
+	var (
+		parts   []globPart
+		literal strings.Builder
+	)
+
+	flush := func() {
+		if literal.Len() > 0 {
+			parts = append(parts, globPart{literal: literal.String()})
+			literal.Reset()
+		}
+	}
+
+	for i := 0; i < len(pattern); i++ {
+		ch := pattern[i]
+
+		switch ch {
+		case '\\':
+			if i+1 < len(pattern) && strings.IndexByte("[]{}", pattern[i+1]) >= 0 {
+				i++
+				literal.WriteByte(pattern[i])
+
+				continue
+			}
+			literal.WriteByte(ch)
+		case '{':
+			end := strings.IndexByte(pattern[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unmatched { in %s", errInvalidGlob, pattern)
+			}
+
+			flush()
+			parts = append(parts, globPart{values: strings.Split(pattern[i+1:i+end], ",")})
+			i += end
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				literal.WriteByte(ch)
+
+				continue
+			}
+
+			values, ok, err := globRange(pattern[i+1 : i+end])
+			if err != nil {
+				return nil, err
+			}
+
+			if !ok {
+				literal.WriteString(pattern[i : i+end+1])
+			} else {
+				flush()
+				parts = append(parts, globPart{values: values})
+			}
+			i += end
+		default:
+			literal.WriteByte(ch)
+		}
+	}
+
+	flush()
+
+	return parts, nil
+}
+
+// globRange expands the inside of a [] glob. ok is false when spec is not
+// a range, so the brackets are meant literally.
+func globRange(spec string) ([]string, bool, error) {
+	if m := globNumericRange.FindStringSubmatch(spec); m != nil {
+		start, err1 := strconv.Atoi(m[1])
+		end, err2 := strconv.Atoi(m[2])
+		step, err := globStep(m[3])
+		if err1 != nil || err2 != nil || err != nil || start > end {
+			return nil, false, fmt.Errorf("%w: [%s]", errInvalidGlob, spec)
+		}
+
+		width := 0
+		if len(m[1]) > 1 && m[1][0] == '0' {
+			width = len(m[1])
+		}
+
+		if (end-start)/step >= globMaxURLs {
+			return nil, false, fmt.Errorf("%w: [%s]", errGlobTooLarge, spec)
+		}
+
+		var values []string
+		for n := start; n <= end; n += step {
+			values = append(values, fmt.Sprintf("%0*d", width, n))
+		}
+
+		return values, true, nil
+	}
+
+	if m := globLetterRange.FindStringSubmatch(spec); m != nil {
+		start, end := m[1][0], m[2][0]
+		step, err := globStep(m[3])
+		if err != nil || start > end || (start <= 'Z') != (end <= 'Z') {
+			return nil, false, fmt.Errorf("%w: [%s]", errInvalidGlob, spec)
+		}
+
+		var values []string
+		for ch := int(start); ch <= int(end); ch += step {
+			values = append(values, string(rune(ch)))
+		}
+
+		return values, true, nil
+	}
+
+	return nil, false, nil
+}
+
+func globStep(s string) (int, error) {
+	if s == "" {
+		return 1, nil
+	}
+
+	step, err := strconv.Atoi(s)
+	if err != nil || step < 1 {
+		return 0, fmt.Errorf("%w: bad step %q", errInvalidGlob, s)
+	}
+
+	return step, nil
+}
+
+// globName fills #1, #2, ... in template with the glob values. The result
+// may contain directories; it must stay inside the output directory.
+func globName(template string, values []string) (urlHint, error) {
+	name := globReference.ReplaceAllStringFunc(template, func(ref string) string {
+		n, _ := strconv.Atoi(ref[1:])
+		if n < 1 || n > len(values) {
+			return ref
+		}
+
+		return values[n-1]
+	})
+
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return urlHint{}, fmt.Errorf("%w: name %q leaves the output directory", errInvalidGlob, name)
+	}
+
+	dir, file := path.Split(name)
+
+	return urlHint{dir: strings.TrimSuffix(dir, "/"), filename: file}, nil
+}
+
+// addURL expands the patterns of raw and queues every valid URL.
+func (c *CLIApplication) addURL(raw string) {
+	c.expandURL(raw, func(url string) bool {
+		c.URLS = append(c.URLS, url)
+
+		return true
+	})
+}
+
+// expandURL expands the patterns of raw and passes every valid URL to
+// emit. It returns false once emit does, which stops the expansion.
+func (c *CLIApplication) expandURL(raw string, emit func(string) bool) bool {
+	if c.globOff {
+		if url, err := parseValidateURL(raw); err == nil {
+			return emit(url)
+		}
+
+		return true
+	}
+
+	expansions, err := expandGlob(raw)
+	if err != nil {
+		slog.Warn("skipping url", logKeyURL, raw, logKeyError, err)
+
+		return true
+	}
+
+	for _, e := range expansions {
+		url, err := parseValidateURL(e.url)
+		if err != nil {
+			continue
+		}
+
+		if c.nameTmpl != "" && len(e.values) > 0 {
+			if h, err := globName(c.nameTmpl, e.values); err != nil {
+				slog.Warn("ignoring output name", logKeyURL, url, logKeyError, err)
+			} else {
+				c.setHint(url, h)
+			}
+		}
+
+		if !emit(url) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func globURLs(t *testing.T, pattern string) []string {
+	t.Helper()
+
+	expansions, err := expandGlob(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	urls := make([]string, len(expansions))
+	for i, e := range expansions {
+		urls[i] = e.url
+	}
+
+	return urls
+}
+
+func TestExpandGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"https://example.com/a.jpg", []string{"https://example.com/a.jpg"}},
+		{"https://example.com/img[008-011].jpg", []string{
+			"https://example.com/img008.jpg", "https://example.com/img009.jpg",
+			"https://example.com/img010.jpg", "https://example.com/img011.jpg",
+		}},
+		{"https://example.com/[0-100:50]", []string{
+			"https://example.com/0", "https://example.com/50", "https://example.com/100",
+		}},
+		{"https://example.com/[a-c].txt", []string{
+			"https://example.com/a.txt", "https://example.com/b.txt", "https://example.com/c.txt",
+		}},
+		{"https://example.com/{x86,arm64}/v[1-2]", []string{
+			"https://example.com/x86/v1", "https://example.com/x86/v2",
+			"https://example.com/arm64/v1", "https://example.com/arm64/v2",
+		}},
+		{"http://[::1]:8080/f[1-2]", []string{"http://[::1]:8080/f1", "http://[::1]:8080/f2"}},
+		{`https://example.com/\[1-2\]`, []string{"https://example.com/[1-2]"}},
+	}
+
+	for _, tt := range tests {
+		if got := globURLs(t, tt.pattern); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("expandGlob(%q) = %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestExpandGlobErrors(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    error
+	}{
+		{"https://example.com/{a,b", errInvalidGlob},
+		{"https://example.com/[9-1]", errInvalidGlob},
+		{"https://example.com/[a-Z]", errInvalidGlob},
+		{"https://example.com/[1-5:0]", errInvalidGlob},
+		{"https://example.com/[1-1000][1-1000]", errGlobTooLarge},
+	}
+
+	for _, tt := range tests {
+		if _, err := expandGlob(tt.pattern); !errors.Is(err, tt.want) {
+			t.Errorf("expandGlob(%q) error = %v, want %v", tt.pattern, err, tt.want)
+		}
+	}
+}
+
+func TestGlobName(t *testing.T) {
+	h, err := globName("#2/photo_#1_#3.jpg", []string{"007", "arm64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.dir != "arm64" || h.filename != "photo_007_#3.jpg" {
+		t.Errorf("globName = %+v", h)
+	}
+
+	if _, err := globName("../#1", []string{"x"}); err == nil {
+		t.Error("expected names outside the output directory to be rejected")
+	}
+}
+
+func TestParseArgsGlob(t *testing.T) {
+	app := &CLIApplication{nameTmpl: "page_#1.html"}
+	app.parseArgs([]string{"https://example.com/?page=[1-3]"})
+
+	if len(app.URLS) != 3 {
+		t.Fatalf("got %d urls, want 3", len(app.URLS))
+	}
+
+	if name := app.hint(app.URLS[2]).filename; name != "page_3.html" {
+		t.Errorf("filename hint = %q, want page_3.html", name)
+	}
+
+	app = &CLIApplication{globOff: true}
+	app.parseArgs([]string{"https://example.com/{a,b}"})
+
+	if len(app.URLS) != 1 {
+		t.Errorf("globoff: got %d urls, want 1", len(app.URLS))
+	}
+}
@@ -18,6 +18,19 @@ const (
 	giga      = kilo * mega
 )
 
+// stringListFlag collects the values of a repeatable flag.
+type stringListFlag []string
+
+func (s *stringListFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringListFlag) Set(value string) error {
+	*s = append(*s, value)
+
+	return nil
+}
+
 func isPiped() bool {
 	fileInfo, err := os.Stdin.Stat()
 	if err != nil {
@@ -31,7 +44,7 @@ func parseValidateURL(in string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("%s %w", errInvalidURL.Error(), err)
 	}
-	if u.Scheme != "http" && u.Scheme != "https" {
+	if !supportedScheme(u.Scheme) {
 		return "", errInvalidURL
 	}
 	return u.String(), nil
@@ -125,43 +138,90 @@ func parseRate(s string) (int64, error) {
 	return int64(result), nil
 }
 
-// deduplicateFilenames renames duplicate filenames by appending a counter.
-// It also checks for files that already exist in outputDir.
-func deduplicateFilenames(resources []*resource, outputDir string) {
-	used := make(map[string]bool)
+// fileNames hands out output paths that are unique among the downloads
+// and the files already in the output directory. Resources are claimed one
+// at a time, as they come out of the pipeline.
+type fileNames struct {
+	used      map[string]bool
+	scanned   map[string]bool
+	outputDir string
+}
 
-	if outputDir != "" {
-		entries, _ := os.ReadDir(outputDir)
+func newFileNames(outputDir string) *fileNames {
+	return &fileNames{
+		used:      make(map[string]bool),
+		scanned:   make(map[string]bool),
+		outputDir: outputDir,
+	}
+}
+
+// claim renames r to file_1.ext, file_2.ext, ... if its path is taken.
+func (fn *fileNames) claim(r *resource) {
+	if fn.outputDir != "" && !fn.scanned[r.dir] {
+		fn.scanned[r.dir] = true
+
+		entries, _ := os.ReadDir(filepath.Join(fn.outputDir, filepath.FromSlash(r.dir)))
 		for _, e := range entries {
 			if !e.IsDir() {
-				used[e.Name()] = true
+				fn.used[filepath.Join(filepath.FromSlash(r.dir), e.Name())] = true
 			}
 		}
 	}
 
-	for _, r := range resources {
-		if !used[r.filename] {
-			used[r.filename] = true
+	if !fn.used[r.path()] {
+		fn.used[r.path()] = true
+
+		return
+	}
+
+	ext := filepath.Ext(r.filename)
+	base := strings.TrimSuffix(r.filename, ext)
+	counter := 1
 
-			continue
+	for {
+		r.filename = fmt.Sprintf("%s_%d%s", base, counter, ext)
+		if !fn.used[r.path()] {
+			fn.used[r.path()] = true
+
+			break
 		}
 
-		ext := filepath.Ext(r.filename)
-		base := strings.TrimSuffix(r.filename, ext)
-		counter := 1
+		counter++
+	}
+}
 
-		for {
-			candidate := fmt.Sprintf("%s_%d%s", base, counter, ext)
-			if !used[candidate] {
-				r.filename = candidate
-				used[candidate] = true
+// reserve marks dir/filename as taken, for names handed out by an earlier
+// run.
+func (fn *fileNames) reserve(dir, filename string) {
+	fn.used[filepath.Join(filepath.FromSlash(dir), filename)] = true
+}
 
-				break
-			}
+// deduplicateFilenames renames duplicate filenames by appending a counter.
+// It also checks for files that already exist in outputDir. Names only
+// clash within the same directory.
+func deduplicateFilenames(resources []*resource, outputDir string) {
+	names := newFileNames(outputDir)
+	for _, r := range resources {
+		names.claim(r)
+	}
+}
 
-			counter++
-		}
+// writeFileAtomic replaces path with data through a temporary file, so a
+// crash never leaves a half written file behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+
+	if err := os.WriteFile(tmp, data, permFile); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
 	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	return nil
 }
 
 // formatBytes formats byte count to human readable string.
@@ -15,7 +15,8 @@ func TestParseValidateURL(t *testing.T) {
 	}{
 		{"valid http", "http://example.com/file.zip", "http://example.com/file.zip", false},
 		{"valid https", "https://example.com/file.zip", "https://example.com/file.zip", false},
-		{"ftp scheme", "ftp://example.com/file.zip", "", true},
+		{"ftp scheme", "ftp://example.com/file.zip", "ftp://example.com/file.zip", false},
+		{"unsupported scheme", "gopher://example.com/file.zip", "", true},
 		{"no scheme", "example.com/file.zip", "", true},
 		{"empty", "", "", true},
 	}
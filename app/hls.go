@@ -0,0 +1,584 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	neturl "net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errHLSPlaylist    = errors.New("invalid hls playlist")
+	errHLSUnsupported = errors.New("unsupported hls feature")
+	errHLSNoVariant   = errors.New("no matching hls variant")
+)
+
+const (
+	hlsVariantBest  = "best"
+	hlsVariantWorst = "worst"
+	hlsExtension    = ".m3u8"
+	hlsMaxPlaylist  = 8 * mega
+
+	hlsPlaylistTimeout = 30 * time.Second
+)
+
+type hlsVariant struct {
+	url        string
+	resolution string
+	bandwidth  int64
+}
+
+type hlsKey struct {
+	url string
+	iv  []byte // nil means derive from the media sequence number
+}
+
+type hlsSegment struct {
+	key *hlsKey
+	url string
+	seq int64
+}
+
+// hlsPlaylist is a parsed master or media playlist.
+type hlsPlaylist struct {
+	variants []hlsVariant
+	segments []hlsSegment
+	initURL  string
+}
+
+// hlsMedia is a resolved media playlist ready to be downloaded.
+type hlsMedia struct {
+	segments []hlsSegment
+	initURL  string
+}
+
+func isHLS(url, contentType string) bool {
+	ct := strings.ToLower(contentType)
+	if strings.Contains(ct, "mpegurl") {
+		return true
+	}
+
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(path.Ext(u.Path), hlsExtension)
+}
+
+// parsePlaylist parses an m3u8 playlist, resolving URIs against base.
+func parsePlaylist(r io.Reader, base *neturl.URL) (*hlsPlaylist, error) {
+	scanner := bufio.NewScanner(r)
+
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "#EXTM3U" {
+		return nil, fmt.Errorf("%w: missing #EXTM3U header", errHLSPlaylist)
+	}
+
+	pl := &hlsPlaylist{}
+
+	var (
+		key         *hlsKey
+		seq         int64
+		pendingInf  map[string]string
+		pendingSeg  bool
+		resolveLine = func(ref string) string {
+			u, err := base.Parse(ref)
+			if err != nil {
+				return ref
+			}
+
+			return u.String()
+		}
+	)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		tag, value, _ := strings.Cut(line, ":")
+
+		switch tag {
+		case "#EXT-X-STREAM-INF":
+			pendingInf = parseHLSAttributes(value)
+		case "#EXT-X-MEDIA-SEQUENCE":
+			n, err := strconv.ParseInt(value, 10, bitSize64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: bad media sequence %q", errHLSPlaylist, value)
+			}
+			seq = n
+		case "#EXT-X-KEY":
+			attrs := parseHLSAttributes(value)
+			switch attrs["METHOD"] {
+			case "NONE":
+				key = nil
+			case "AES-128":
+				k := &hlsKey{url: resolveLine(attrs["URI"])}
+				if iv := attrs["IV"]; iv != "" {
+					raw, err := hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(iv, "0x"), "0X"))
+					if err != nil || len(raw) != aes.BlockSize {
+						return nil, fmt.Errorf("%w: bad key IV %q", errHLSPlaylist, iv)
+					}
+					k.iv = raw
+				}
+				key = k
+			default:
+				return nil, fmt.Errorf("%w: encryption method %s", errHLSUnsupported, attrs["METHOD"])
+			}
+		case "#EXT-X-MAP":
+			pl.initURL = resolveLine(parseHLSAttributes(value)["URI"])
+		case "#EXT-X-BYTERANGE":
+			return nil, fmt.Errorf("%w: byte range segments", errHLSUnsupported)
+		case "#EXTINF":
+			pendingSeg = true
+		default:
+			if strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			switch {
+			case pendingInf != nil:
+				bw, _ := strconv.ParseInt(pendingInf["BANDWIDTH"], 10, bitSize64)
+				pl.variants = append(pl.variants, hlsVariant{
+					url:        resolveLine(line),
+					resolution: pendingInf["RESOLUTION"],
+					bandwidth:  bw,
+				})
+				pendingInf = nil
+			case pendingSeg:
+				pl.segments = append(pl.segments, hlsSegment{url: resolveLine(line), key: key, seq: seq})
+				seq++
+				pendingSeg = false
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read playlist: %w", err)
+	}
+
+	if len(pl.variants) == 0 && len(pl.segments) == 0 {
+		return nil, fmt.Errorf("%w: no variants or segments", errHLSPlaylist)
+	}
+
+	return pl, nil
+}
+
+// parseHLSAttributes parses an attribute list like
+// BANDWIDTH=1280000,RESOLUTION=1280x720,CODECS="avc1.4d401f,mp4a.40.2".
+func parseHLSAttributes(s string) map[string]string {
+	attrs := make(map[string]string)
+
+	for s != "" {
+		name, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+			rest = "," + rest
+		}
+
+		attrs[strings.TrimSpace(name)] = value
+		s = strings.TrimPrefix(rest, ",")
+	}
+
+	return attrs
+}
+
+// selectVariant picks a variant: best/worst bandwidth, a resolution such as
+// 1280x720, a height such as 720p, or a bandwidth cap in bits per second.
+func selectVariant(variants []hlsVariant, choice string) (hlsVariant, error) {
+	sorted := make([]hlsVariant, len(variants))
+	copy(sorted, variants)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].bandwidth < sorted[j].bandwidth })
+
+	choice = strings.ToLower(strings.TrimSpace(choice))
+
+	switch {
+	case choice == "" || choice == hlsVariantBest:
+		return sorted[len(sorted)-1], nil
+	case choice == hlsVariantWorst:
+		return sorted[0], nil
+	case strings.Contains(choice, "x"):
+		for i := len(sorted) - 1; i >= 0; i-- {
+			if strings.EqualFold(sorted[i].resolution, choice) {
+				return sorted[i], nil
+			}
+		}
+	case strings.HasSuffix(choice, "p"):
+		height := strings.TrimSuffix(choice, "p")
+		for i := len(sorted) - 1; i >= 0; i-- {
+			if _, h, ok := strings.Cut(sorted[i].resolution, "x"); ok && h == height {
+				return sorted[i], nil
+			}
+		}
+	default:
+		limit, err := strconv.ParseInt(choice, 10, bitSize64)
+		if err != nil {
+			return hlsVariant{}, fmt.Errorf("%w: %q", errHLSNoVariant, choice)
+		}
+		for i := len(sorted) - 1; i >= 0; i-- {
+			if sorted[i].bandwidth <= limit {
+				return sorted[i], nil
+			}
+		}
+	}
+
+	return hlsVariant{}, fmt.Errorf("%w: %q", errHLSNoVariant, choice)
+}
+
+// fetchBytes reads a whole (small) remote file such as a playlist or a key.
+func (c *CLIApplication) fetchBytes(ctx context.Context, url string, limit int64) ([]byte, error) {
+	f, err := c.fetcherFor(url)
+	if err != nil {
+		return nil, err
+	}
+
+	body, _, err := f.openStream(ctx, url, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = body.Close() }()
+
+	data, err := io.ReadAll(io.LimitReader(body, limit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", url, err)
+	}
+
+	return data, nil
+}
+
+// resolveHLS loads the playlist at url and, for master playlists, the
+// media playlist of the selected variant.
+func (c *CLIApplication) resolveHLS(ctx context.Context, url string) (*hlsMedia, error) {
+	for range 2 {
+		data, err := c.fetchBytes(ctx, url, hlsMaxPlaylist)
+		if err != nil {
+			return nil, err
+		}
+
+		base, err := neturl.Parse(url)
+		if err != nil {
+			return nil, fmt.Errorf("%s %w", errInvalidURL.Error(), err)
+		}
+
+		pl, err := parsePlaylist(bytes.NewReader(data), base)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(pl.variants) == 0 {
+			return &hlsMedia{segments: pl.segments, initURL: pl.initURL}, nil
+		}
+
+		v, err := selectVariant(pl.variants, c.hlsVariant)
+		if err != nil {
+			return nil, err
+		}
+
+		slog.Debug("hls variant selected", logKeyURL, v.url, "bandwidth", v.bandwidth, "resolution", v.resolution)
+		url = v.url
+	}
+
+	return nil, fmt.Errorf("%w: nested master playlists", errHLSPlaylist)
+}
+
+// prepareHLS turns a probed playlist resource into a stream download. The
+// segments are concatenated, so the output gets a .ts or, for fragmented
+// MP4 streams, a .mp4 extension.
+func (c *CLIApplication) prepareHLS(ctx context.Context, r *resource) error {
+	// the master and the media playlist are fetched one after the other,
+	// they get more time than a single probe
+	ctx, cancel := context.WithTimeout(ctx, hlsPlaylistTimeout)
+	defer cancel()
+
+	media, err := c.resolveHLS(ctx, r.url)
+	if err != nil {
+		return err
+	}
+
+	r.hls = media
+	r.length = -1
+	r.contentType = ""
+
+	ext := ".ts"
+	if media.initURL != "" {
+		ext = ".mp4"
+	}
+
+	parsed, _ := neturl.Parse(r.url)
+	base := strings.TrimSuffix(path.Base(parsed.Path), path.Ext(parsed.Path))
+	if base == "" || base == "." || base == "/" {
+		base = "stream"
+	}
+
+	r.filename = base + ext
+
+	slog.Debug("hls playlist", logKeyURL, r.url, "segments", len(media.segments))
+
+	return nil
+}
+
+// hlsKeyCache fetches every key once.
+type hlsKeyCache struct {
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+func (kc *hlsKeyCache) get(ctx context.Context, c *CLIApplication, url string) ([]byte, error) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	if key, ok := kc.keys[url]; ok {
+		return key, nil
+	}
+
+	key, err := c.fetchBytes(ctx, url, aes.BlockSize+1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch key: %w", err)
+	}
+
+	if len(key) != aes.BlockSize {
+		return nil, fmt.Errorf("%w: key is %d bytes", errHLSPlaylist, len(key))
+	}
+
+	kc.keys[url] = key
+
+	return key, nil
+}
+
+// decryptSegment decrypts an AES-128-CBC segment and strips PKCS#7 padding.
+func decryptSegment(data, key []byte, k *hlsKey, seq int64) ([]byte, error) {
+	if len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("%w: encrypted segment is not block aligned", errHLSPlaylist)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	iv := k.iv
+	if iv == nil {
+		iv = make([]byte, aes.BlockSize)
+		binary.BigEndian.PutUint64(iv[aes.BlockSize-8:], uint64(seq))
+	}
+
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+
+	if len(out) == 0 {
+		return out, nil
+	}
+
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > aes.BlockSize || pad > len(out) {
+		return nil, fmt.Errorf("%w: bad padding", errHLSPlaylist)
+	}
+
+	return out[:len(out)-pad], nil
+}
+
+// downloadHLS fetches all segments concurrently and writes them to the
+// output file in playlist order. It continues after the last segment a
+// stopped attempt wrote, as recorded in the state file.
+func (c *CLIApplication) downloadHLS(
+	ctx context.Context, r *resource, outputPath, partPath string, downloaded *atomic.Int64,
+) error {
+	statePath := partPath + chunkStateSuffix
+	st := loadHLSState(statePath, partPath, len(r.hls.allSegments()))
+
+	out, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY, permFile)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer func() { _ = out.Close() }()
+
+	// anything after the last recorded segment is from an unfinished write
+	if err := out.Truncate(st.Size); err != nil {
+		return fmt.Errorf("failed to truncate file: %w", err)
+	}
+	if _, err := out.Seek(st.Size, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek file: %w", err)
+	}
+
+	if st.Done > 0 {
+		slog.Info("resuming hls download", logKeyFile, r.path(), "segment", st.Done)
+	}
+
+	st.Segments = len(r.hls.allSegments())
+	downloaded.Store(st.Size)
+	lastSave := time.Now()
+
+	save := func() {
+		// the state must never claim segments a crash could still lose
+		if err := out.Sync(); err != nil {
+			slog.Debug("failed to sync part file", "path", partPath, logKeyError, err)
+
+			return
+		}
+
+		if err := saveHLSState(statePath, st); err != nil {
+			slog.Debug("failed to save hls state", "path", statePath, logKeyError, err)
+		}
+	}
+
+	err = c.writeHLS(ctx, r, out, downloaded, st.Done, func(n int) {
+		st.Done++
+		st.Size += int64(n)
+
+		if time.Since(lastSave) >= chunkStateInterval {
+			save()
+			lastSave = time.Now()
+		}
+	})
+	if err != nil {
+		save()
+
+		return err
+	}
+
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	if err := finalizePart(partPath, outputPath); err != nil {
+		return err
+	}
+
+	_ = os.Remove(statePath)
+
+	return nil
+}
+
+// allSegments returns the segments to fetch, the init segment first if
+// the playlist has one.
+func (p *hlsMedia) allSegments() []hlsSegment {
+	if p.initURL == "" {
+		return p.segments
+	}
+
+	return append([]hlsSegment{{url: p.initURL}}, p.segments...)
+}
+
+// writeHLS fetches the segments from the one at index from on concurrently
+// and writes them to w in playlist order, calling written, if set, with the
+// size of each. At most chunkSize segments are held in memory at once.
+func (c *CLIApplication) writeHLS(
+	ctx context.Context, r *resource, w io.Writer, downloaded *atomic.Int64, from int, written func(int),
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	segments := r.hls.allSegments()[from:]
+
+	keys := &hlsKeyCache{keys: make(map[string][]byte)}
+	results := make([]chan orderedPart, len(segments))
+	for i := range results {
+		results[i] = make(chan orderedPart, 1)
+	}
+
+	workers := max(c.chunkSize, 1)
+	slots := make(chan struct{}, workers)
+
+	go func() {
+		for i, seg := range segments {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				results[i] <- orderedPart{err: ctx.Err()}
+
+				continue
+			}
+
+			go func() {
+				data, err := c.fetchSegment(ctx, seg, keys, r.limiter)
+				results[i] <- orderedPart{data: data, err: err}
+			}()
+		}
+	}()
+
+	for i := range segments {
+		part := <-results[i]
+		if part.err != nil {
+			return fmt.Errorf("segment %d failed: %w", from+i, part.err)
+		}
+
+		if _, err := w.Write(part.data); err != nil {
+			return fmt.Errorf("failed to write file: %w", err)
+		}
+
+		// counted as written, decrypted, like the size in the hls state
+		downloaded.Add(int64(len(part.data)))
+
+		if written != nil {
+			written(len(part.data))
+		}
+
+		<-slots
+		slog.Debug("hls segment written", logKeyFile, r.filename, "segment", from+i)
+	}
+
+	return nil
+}
+
+func (c *CLIApplication) fetchSegment(
+	ctx context.Context, seg hlsSegment, keys *hlsKeyCache, file *rateLimiter,
+) ([]byte, error) {
+	f, err := c.fetcherFor(seg.url)
+	if err != nil {
+		return nil, err
+	}
+
+	body, _, err := f.openStream(ctx, seg.url, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(c.throttle(ctx, body, seg.url, file))
+
+	// close before fetching the key, the connection slot may be needed for it
+	_ = body.Close()
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read segment: %w", err)
+	}
+
+	if seg.key == nil {
+		return data, nil
+	}
+
+	key, err := keys.get(ctx, c, seg.key.url)
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptSegment(data, key, seg.key, seg.seq)
+}
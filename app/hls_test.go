@@ -0,0 +1,364 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testMasterPlaylist = `#EXTM3U
+#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,CODECS="avc1.4d401f,mp4a.40.2"
+low/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2500000,RESOLUTION=1280x720
+mid/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=5000000,RESOLUTION=1920x1080
+https://cdn.example.com/high/index.m3u8
+`
+
+func TestParsePlaylistMaster(t *testing.T) {
+	base, _ := neturl.Parse("https://example.com/video/master.m3u8")
+
+	pl, err := parsePlaylist(strings.NewReader(testMasterPlaylist), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pl.variants) != 3 {
+		t.Fatalf("variants = %d, want 3", len(pl.variants))
+	}
+	if pl.variants[0].url != "https://example.com/video/low/index.m3u8" {
+		t.Errorf("variant url = %q, want resolved relative url", pl.variants[0].url)
+	}
+	if pl.variants[1].bandwidth != 2500000 || pl.variants[1].resolution != "1280x720" {
+		t.Errorf("unexpected variant %+v", pl.variants[1])
+	}
+}
+
+func TestParsePlaylistMedia(t *testing.T) {
+	base, _ := neturl.Parse("https://example.com/video/index.m3u8")
+	input := `#EXTM3U
+#EXT-X-MEDIA-SEQUENCE:7
+#EXTINF:4.0,
+seg0.ts
+#EXT-X-KEY:METHOD=AES-128,URI="key.bin",IV=0x000102030405060708090a0b0c0d0e0f
+#EXTINF:4.0,
+seg1.ts
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:4.0,
+seg2.ts
+#EXT-X-ENDLIST
+`
+
+	pl, err := parsePlaylist(strings.NewReader(input), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pl.segments) != 3 {
+		t.Fatalf("segments = %d, want 3", len(pl.segments))
+	}
+	if pl.segments[0].key != nil || pl.segments[2].key != nil {
+		t.Error("expected unencrypted first and last segments")
+	}
+
+	k := pl.segments[1].key
+	if k == nil || k.url != "https://example.com/video/key.bin" || len(k.iv) != aes.BlockSize {
+		t.Errorf("unexpected key %+v", k)
+	}
+	if pl.segments[1].seq != 8 {
+		t.Errorf("seq = %d, want 8", pl.segments[1].seq)
+	}
+}
+
+func TestParsePlaylistErrors(t *testing.T) {
+	base, _ := neturl.Parse("https://example.com/index.m3u8")
+
+	tests := []struct {
+		name  string
+		input string
+		want  error
+	}{
+		{"no header", "seg0.ts\n", errHLSPlaylist},
+		{"empty", "#EXTM3U\n", errHLSPlaylist},
+		{"sample aes", "#EXTM3U\n#EXT-X-KEY:METHOD=SAMPLE-AES,URI=\"k\"\n#EXTINF:1,\na.ts\n", errHLSUnsupported},
+		{"byte range", "#EXTM3U\n#EXTINF:1,\n#EXT-X-BYTERANGE:100@0\na.ts\n", errHLSUnsupported},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePlaylist(strings.NewReader(tt.input), base)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHLSAttributes(t *testing.T) {
+	attrs := parseHLSAttributes(`BANDWIDTH=1280000,CODECS="avc1.4d401f,mp4a.40.2",RESOLUTION=1280x720`)
+
+	if attrs["BANDWIDTH"] != "1280000" {
+		t.Errorf("BANDWIDTH = %q", attrs["BANDWIDTH"])
+	}
+	if attrs["CODECS"] != "avc1.4d401f,mp4a.40.2" {
+		t.Errorf("CODECS = %q", attrs["CODECS"])
+	}
+	if attrs["RESOLUTION"] != "1280x720" {
+		t.Errorf("RESOLUTION = %q", attrs["RESOLUTION"])
+	}
+}
+
+func TestSelectVariant(t *testing.T) {
+	variants := []hlsVariant{
+		{url: "mid", bandwidth: 2500000, resolution: "1280x720"},
+		{url: "low", bandwidth: 800000, resolution: "640x360"},
+		{url: "high", bandwidth: 5000000, resolution: "1920x1080"},
+	}
+
+	tests := []struct {
+		choice  string
+		want    string
+		wantErr bool
+	}{
+		{"", "high", false},
+		{"best", "high", false},
+		{"worst", "low", false},
+		{"1280x720", "mid", false},
+		{"360p", "low", false},
+		{"3000000", "mid", false},
+		{"100", "", true},
+		{"480p", "", true},
+		{"bogus", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.choice, func(t *testing.T) {
+			got, err := selectVariant(variants, tt.choice)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectVariant(%q) error = %v, wantErr %v", tt.choice, err, tt.wantErr)
+			}
+			if got.url != tt.want {
+				t.Errorf("selectVariant(%q) = %q, want %q", tt.choice, got.url, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsHLS(t *testing.T) {
+	if !isHLS("https://example.com/live/index.M3U8?token=1", "") {
+		t.Error("expected .m3u8 extension to be detected")
+	}
+	if !isHLS("https://example.com/playlist", "application/vnd.apple.mpegurl") {
+		t.Error("expected mpegurl content type to be detected")
+	}
+	if isHLS("https://example.com/file.ts", "video/mp2t") {
+		t.Error("expected plain file not to be detected as hls")
+	}
+}
+
+func encryptTestSegment(t *testing.T, data, key, iv []byte) []byte {
+	t.Helper()
+
+	pad := aes.BlockSize - len(data)%aes.BlockSize
+	padded := append(append([]byte{}, data...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, padded)
+
+	return out
+}
+
+func TestDecryptSegmentSequenceIV(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	iv := make([]byte, aes.BlockSize)
+	iv[aes.BlockSize-1] = 3
+
+	enc := encryptTestSegment(t, []byte("segment payload"), key, iv)
+
+	got, err := decryptSegment(enc, key, &hlsKey{}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "segment payload" {
+		t.Errorf("decrypted %q", got)
+	}
+
+	if _, err := decryptSegment(enc[:5], key, &hlsKey{}, 3); err == nil {
+		t.Error("expected error for unaligned data")
+	}
+}
+
+func TestDownloadHLS(t *testing.T) {
+	key := []byte("fedcba9876543210")
+	segments := map[string][]byte{
+		"/v/seg0.ts": []byte("first-"),
+		"/v/seg2.ts": []byte("-third"),
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	iv[aes.BlockSize-1] = 1
+	segments["/v/seg1.ts"] = encryptTestSegment(t, []byte("second"), key, iv)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/master.m3u8":
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			w.Write([]byte("#EXTM3U\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=100\nbad/index.m3u8\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=900\nv/index.m3u8\n"))
+		case "/v/index.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXTINF:1,\nseg0.ts\n" +
+				"#EXT-X-KEY:METHOD=AES-128,URI=\"/keys/k1\"\n#EXTINF:1,\nseg1.ts\n" +
+				"#EXT-X-KEY:METHOD=NONE\n#EXTINF:1,\nseg2.ts\n#EXT-X-ENDLIST\n"))
+		case "/keys/k1":
+			w.Write(key)
+		default:
+			data, ok := segments[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+
+				return
+			}
+			w.Write(data)
+		}
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	app := &CLIApplication{
+		Client:     ts.Client(),
+		chunkSize:  2,
+		limiter:    newRateLimiter(0),
+		outputDir:  dir,
+		hlsVariant: hlsVariantBest,
+	}
+
+	r, err := app.getResourceInformation(context.Background(), ts.URL+"/master.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.filename != "master.ts" {
+		t.Errorf("filename = %q, want 'master.ts'", r.filename)
+	}
+	if r.hls == nil || len(r.hls.segments) != 3 {
+		t.Fatalf("expected 3 hls segments, got %+v", r.hls)
+	}
+
+	pd := newProgressDisplay()
+	done := make(chan downloadResult, 1)
+	go app.download(context.Background(), r, done, pd)
+
+	if result := <-done; !result.ok {
+		t.Fatal("expected hls download to succeed")
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "master.ts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "first-second-third" {
+		t.Errorf("output = %q, want 'first-second-third'", got)
+	}
+}
+
+func TestDownloadHLSResumesAtSegment(t *testing.T) {
+	var fetched sync.Map
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/index.m3u8" {
+			w.Write([]byte("#EXTM3U\n#EXTINF:1,\nseg0.ts\n#EXTINF:1,\nseg1.ts\n#EXTINF:1,\nseg2.ts\n#EXT-X-ENDLIST\n"))
+
+			return
+		}
+
+		fetched.Store(r.URL.Path, true)
+		w.Write([]byte(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), ".ts") + "-"))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	app := &CLIApplication{
+		Client:    ts.Client(),
+		chunkSize: 2,
+		limiter:   newRateLimiter(0),
+		outputDir: dir,
+	}
+
+	r, err := app.getResourceInformation(context.Background(), ts.URL+"/index.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a stopped attempt wrote the first segment and part of the second
+	partPath := filepath.Join(dir, r.path()) + ".part"
+	if err := os.WriteFile(partPath, []byte("seg0-se"), permFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveHLSState(partPath+chunkStateSuffix, hlsState{Segments: 3, Done: 1, Size: 5}); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan downloadResult, 1)
+	go app.download(context.Background(), r, done, newProgressDisplay())
+
+	if result := <-done; !result.ok {
+		t.Fatal("expected hls download to succeed")
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, r.path()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "seg0-seg1-seg2-" {
+		t.Errorf("output = %q, want 'seg0-seg1-seg2-'", got)
+	}
+	if _, ok := fetched.Load("/seg0.ts"); ok {
+		t.Error("a segment already written should not be fetched again")
+	}
+	if _, err := os.Stat(partPath + chunkStateSuffix); !os.IsNotExist(err) {
+		t.Error("the hls state should be removed once the download completes")
+	}
+}
+
+func TestPrepareHLSOutlastsProbeTimeout(t *testing.T) {
+	defer func(d time.Duration) { probeTimeout = d }(probeTimeout)
+	probeTimeout = 100 * time.Millisecond
+
+	// each playlist is quick enough for a probe, both together are not
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(60 * time.Millisecond)
+
+		switch r.URL.Path {
+		case "/master.m3u8":
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			w.Write([]byte("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=100\nv/index.m3u8\n"))
+		case "/v/index.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXTINF:1,\nseg0.ts\n#EXT-X-ENDLIST\n"))
+		}
+	}))
+	defer ts.Close()
+
+	app := &CLIApplication{Client: ts.Client(), chunkSize: 1, hlsVariant: hlsVariantBest}
+
+	r, err := app.getResourceInformation(context.Background(), ts.URL+"/master.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.hls == nil || len(r.hls.segments) != 1 {
+		t.Errorf("expected 1 hls segment, got %+v", r.hls)
+	}
+}
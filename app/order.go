@@ -0,0 +1,183 @@
+package app
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+var errInvalidOrder = errors.New("invalid order")
+
+// orderPolicy decides which queued download starts next.
+type orderPolicy int
+
+const (
+	orderInput    orderPolicy = iota // as listed
+	orderSmallest                    // smallest file first, unknown sizes last
+	orderLargest                     // largest file first, unknown sizes last
+	orderPriority                    // highest priority= first
+)
+
+func parseOrder(s string) (orderPolicy, error) {
+	switch s {
+	case "input":
+		return orderInput, nil
+	case "smallest":
+		return orderSmallest, nil
+	case "largest":
+		return orderLargest, nil
+	case "priority":
+		return orderPriority, nil
+	}
+
+	return orderInput, fmt.Errorf("%w %q, want input, smallest, largest or priority", errInvalidOrder, s)
+}
+
+// before reports whether a should start before b. Ties keep the input
+// order.
+func (p orderPolicy) before(a, b queuedResource) bool {
+	switch p {
+	case orderSmallest, orderLargest:
+		ak, bk := a.r.length > 0, b.r.length > 0
+		if ak != bk {
+			return ak
+		}
+
+		if a.r.length != b.r.length {
+			return (a.r.length < b.r.length) == (p == orderSmallest)
+		}
+	case orderPriority:
+		if a.r.priority != b.r.priority {
+			return a.r.priority > b.r.priority
+		}
+	case orderInput:
+	}
+
+	return a.seq < b.seq
+}
+
+// queuedResource is a resource waiting in the reorder heap; seq is its
+// position in the input.
+type queuedResource struct {
+	r   *resource
+	seq int
+}
+
+type resourceHeap struct {
+	items  []queuedResource
+	policy orderPolicy
+}
+
+func (h *resourceHeap) Len() int           { return len(h.items) }
+func (h *resourceHeap) Less(i, j int) bool { return h.policy.before(h.items[i], h.items[j]) }
+func (h *resourceHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *resourceHeap) Push(x any)         { h.items = append(h.items, x.(queuedResource)) }
+
+func (h *resourceHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+
+	return last
+}
+
+// reorder passes the resources from in on by policy: whenever a worker is
+// free it gets the best of the resources that have arrived so far. Unlike
+// input order this holds every waiting resource in memory.
+func (p orderPolicy) reorder(in <-chan *resource) <-chan *resource {
+	if p == orderInput {
+		return in
+	}
+
+	out := make(chan *resource)
+
+	go func() {
+		defer close(out)
+
+		h := &resourceHeap{policy: p}
+		seq := 0
+
+		push := func(r *resource) {
+			heap.Push(h, queuedResource{r: r, seq: seq})
+			seq++
+		}
+
+		for in != nil || h.Len() > 0 {
+			// take in whatever has arrived before picking the next one
+			if in != nil && h.Len() > 0 {
+				select {
+				case r, ok := <-in:
+					if !ok {
+						in = nil
+					} else {
+						push(r)
+					}
+
+					continue
+				default:
+				}
+			}
+
+			var (
+				send chan<- *resource
+				next *resource
+			)
+
+			if h.Len() > 0 {
+				send, next = out, h.items[0].r
+			}
+
+			select {
+			case r, ok := <-in:
+				if !ok {
+					in = nil
+
+					continue
+				}
+				push(r)
+			case send <- next:
+				heap.Pop(h)
+			}
+		}
+	}()
+
+	return out
+}
+
+// addLine queues the URLs of one line of input: a URL, or pattern,
+// optionally followed by options, e.g. "https://example.com/a.iso priority=10".
+func (c *CLIApplication) addLine(line string, emit func(string) bool) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return true
+	}
+
+	var priority int
+
+	for _, opt := range fields[1:] {
+		key, value, _ := strings.Cut(opt, "=")
+		if key != "priority" {
+			slog.Warn("ignoring unknown option", "option", opt, logKeyURL, fields[0])
+
+			continue
+		}
+
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			slog.Warn("ignoring invalid priority", "option", opt, logKeyURL, fields[0])
+
+			continue
+		}
+		priority = n
+	}
+
+	return c.expandURL(fields[0], func(url string) bool {
+		if priority != 0 {
+			c.setPriority(url, priority)
+		}
+
+		return emit(url)
+	})
+}
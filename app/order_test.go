@@ -0,0 +1,158 @@
+package app
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseOrder(t *testing.T) {
+	tests := []struct {
+		input string
+		want  orderPolicy
+	}{
+		{"input", orderInput},
+		{"smallest", orderSmallest},
+		{"largest", orderLargest},
+		{"priority", orderPriority},
+	}
+
+	for _, tt := range tests {
+		got, err := parseOrder(tt.input)
+		if err != nil || got != tt.want {
+			t.Errorf("parseOrder(%q) = %v, %v, want %v", tt.input, got, err, tt.want)
+		}
+	}
+
+	if _, err := parseOrder("random"); !errors.Is(err, errInvalidOrder) {
+		t.Errorf("expected errInvalidOrder, got %v", err)
+	}
+}
+
+// reorderAll queues resources behind a worker that isn't taking any yet
+// and returns the order the policy hands them out in.
+func reorderAll(policy orderPolicy, resources []*resource) []*resource {
+	in := make(chan *resource, len(resources))
+	for _, r := range resources {
+		in <- r
+	}
+	close(in)
+
+	out := policy.reorder(in)
+
+	// the reorder stage takes in everything buffered before it sends
+	var got []*resource
+	for r := range out {
+		got = append(got, r)
+	}
+
+	return got
+}
+
+func TestOrderPolicies(t *testing.T) {
+	a := &resource{filename: "a", length: 300, priority: 1}
+	b := &resource{filename: "b", length: -1, priority: 5}
+	c := &resource{filename: "c", length: 100}
+	d := &resource{filename: "d", length: 200, priority: 5}
+
+	tests := []struct {
+		policy orderPolicy
+		want   string
+	}{
+		{orderInput, "abcd"},
+		{orderSmallest, "cdab"},
+		{orderLargest, "adcb"},
+		{orderPriority, "bdac"},
+	}
+
+	for _, tt := range tests {
+		var got string
+		for _, r := range reorderAll(tt.policy, []*resource{a, b, c, d}) {
+			got += r.filename
+		}
+
+		if got != tt.want {
+			t.Errorf("policy %d: order = %s, want %s", tt.policy, got, tt.want)
+		}
+	}
+}
+
+func TestJobQueueHonorsOrder(t *testing.T) {
+	q := newJobQueue(1, orderSmallest)
+
+	in := make(chan *resource)
+	started := make(chan string)
+	release := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		q.run(in, func(r *resource) {
+			started <- r.filename
+			<-release
+		})
+		close(finished)
+	}()
+
+	// the first file keeps the only worker busy while the rest queue up
+	in <- &resource{filename: "first", length: 50}
+	if got := <-started; got != "first" {
+		t.Fatalf("started %s, want first", got)
+	}
+
+	in <- &resource{filename: "big", length: 900}
+	in <- &resource{filename: "small", length: 10}
+	in <- &resource{filename: "medium", length: 100}
+	close(in)
+
+	var got []string
+	for range 3 {
+		release <- struct{}{}
+		got = append(got, <-started)
+	}
+	release <- struct{}{}
+	<-finished
+
+	want := []string{"small", "medium", "big"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("start order = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestAddLinePriority(t *testing.T) {
+	app := &CLIApplication{}
+
+	var urls []string
+	emit := func(url string) bool {
+		urls = append(urls, url)
+
+		return true
+	}
+
+	app.addLine("https://example.com/a.iso priority=10", emit)
+	app.addLine("https://example.com/b.iso   priority=x", emit)
+	app.addLine("https://example.com/c.iso colour=red", emit)
+
+	if len(urls) != 3 {
+		t.Fatalf("urls = %v, want 3", urls)
+	}
+
+	if got := app.hint("https://example.com/a.iso").priority; got != 10 {
+		t.Errorf("priority = %d, want 10", got)
+	}
+
+	if got := app.hint("https://example.com/b.iso").priority; got != 0 {
+		t.Errorf("priority = %d, want 0 for an invalid value", got)
+	}
+}
+
+func TestAddLineKeepsNameHint(t *testing.T) {
+	app := &CLIApplication{nameTmpl: "part-#1.bin"}
+
+	app.addLine("https://example.com/[1-2].bin priority=3", func(string) bool { return true })
+
+	h := app.hint("https://example.com/2.bin")
+	if h.filename != "part-2.bin" || h.priority != 3 {
+		t.Errorf("hint = %+v, want part-2.bin with priority 3", h)
+	}
+}
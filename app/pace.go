@@ -0,0 +1,246 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	paceMinBackoff = time.Second
+	paceMaxBackoff = time.Minute
+
+	// paceMaxRetryAfter caps the Retry-After of a server, so a silly value
+	// can't stall the run for hours
+	paceMaxRetryAfter = 10 * time.Minute
+
+	// paceMaxRetries is how often a request answered with 429 is sent again
+	paceMaxRetries = 3
+)
+
+// tooManyRequestsError is a 429 response, with the delay the server asked
+// for in Retry-After.
+type tooManyRequestsError struct {
+	retryAfter time.Duration
+}
+
+func (e *tooManyRequestsError) Error() string {
+	if e.retryAfter > 0 {
+		return fmt.Sprintf("%s: returned 429, retry after %s", errHTTPStatusIsNotOK, e.retryAfter)
+	}
+
+	return errHTTPStatusIsNotOK.Error() + ": returned 429"
+}
+
+func (e *tooManyRequestsError) Unwrap() error {
+	return errHTTPStatusIsNotOK
+}
+
+func isTooManyRequests(err error) bool {
+	var tooMany *tooManyRequestsError
+
+	return errors.As(err, &tooMany)
+}
+
+func newTooManyRequestsError(resp *http.Response) error {
+	return &tooManyRequestsError{retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+}
+
+// parseRetryAfter reads a Retry-After header, given in seconds or as an
+// HTTP date. It returns 0 if the header is missing or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return min(max(time.Duration(seconds)*time.Second, 0), paceMaxRetryAfter)
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		return min(max(at.Sub(now), 0), paceMaxRetryAfter)
+	}
+
+	return 0
+}
+
+// hostPacer spaces out requests to the same host: -wait between them,
+// varied between 0.5 and 1.5 times with -random-wait, plus a backoff that
+// doubles every time the host answers 429 Too Many Requests and stays for
+// the rest of the run.
+type hostPacer struct {
+	hosts  map[string]*hostPace
+	gap    time.Duration
+	random bool
+	mu     sync.Mutex
+}
+
+type hostPace struct {
+	next    time.Time // earliest time of the next request
+	backoff time.Duration
+}
+
+func newHostPacer(gap time.Duration, random bool) *hostPacer {
+	return &hostPacer{
+		hosts:  make(map[string]*hostPace),
+		gap:    gap,
+		random: random,
+	}
+}
+
+// host returns the pace of the host of url. Callers hold mu.
+func (p *hostPacer) host(url string) (string, *hostPace) {
+	var name string
+	if u, err := neturl.Parse(url); err == nil {
+		name = u.Hostname()
+	}
+
+	h, ok := p.hosts[name]
+	if !ok {
+		h = &hostPace{}
+		p.hosts[name] = h
+	}
+
+	return name, h
+}
+
+// interval is the time to leave after a request to h. Callers hold mu.
+func (p *hostPacer) interval(h *hostPace) time.Duration {
+	gap := p.gap
+	if p.random && gap > 0 {
+		gap = time.Duration(float64(gap) * (0.5 + rand.Float64())) //nolint:gosec // jitter, not a secret
+	}
+
+	return gap + h.backoff
+}
+
+// wait blocks until a request to the host of url may be sent, or ctx is
+// done. Each call takes the next free turn of the host.
+func (p *hostPacer) wait(ctx context.Context, url string) error {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	_, h := p.host(url)
+
+	start := time.Now()
+	if h.next.After(start) {
+		start = h.next
+	}
+
+	h.next = start.Add(p.interval(h))
+	p.mu.Unlock()
+
+	delay := time.Until(start)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// slowDown backs off the host of url after a 429: the gap between its
+// requests grows and nothing is sent before retryAfter has passed.
+func (p *hostPacer) slowDown(url string, retryAfter time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	name, h := p.host(url)
+	h.backoff = min(max(h.backoff*2, paceMinBackoff), paceMaxBackoff)
+
+	if next := time.Now().Add(max(retryAfter, h.backoff)); next.After(h.next) {
+		h.next = next
+	}
+
+	slog.Warn("host is rate limiting, slowing down", "host", name, "backoff", h.backoff)
+}
+
+// pacedFetcher tells the pacer about 429 responses of the wrapped fetcher
+// and sends the request again once the host's turn comes. Probes are only
+// reported, they are retried outside their timeout by
+// getResourceInformation.
+type pacedFetcher struct {
+	fetcher
+	pacer *hostPacer
+}
+
+// retry runs op until it gets no 429, at most paceMaxRetries times more,
+// waiting for the turn of the host in between.
+func (f *pacedFetcher) retry(ctx context.Context, url string, op func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := op()
+		f.check(url, err)
+
+		if !isTooManyRequests(err) || attempt == paceMaxRetries {
+			return err
+		}
+
+		if waitErr := f.pacer.wait(ctx, url); waitErr != nil {
+			return err
+		}
+
+		slog.Debug("retrying after 429", logKeyURL, url, "attempt", attempt+1)
+	}
+}
+
+func (f *pacedFetcher) check(url string, err error) {
+	var tooMany *tooManyRequestsError
+	if errors.As(err, &tooMany) {
+		f.pacer.slowDown(url, tooMany.retryAfter)
+	}
+}
+
+func (f *pacedFetcher) probe(ctx context.Context, url string) (*remoteInfo, error) {
+	info, err := f.fetcher.probe(ctx, url)
+	f.check(url, err)
+
+	return info, err
+}
+
+func (f *pacedFetcher) openRange(ctx context.Context, url string, start, end int64) (io.ReadCloser, error) {
+	var body io.ReadCloser
+
+	err := f.retry(ctx, url, func() error {
+		var err error
+		body, err = f.fetcher.openRange(ctx, url, start, end)
+
+		return err
+	})
+
+	return body, err
+}
+
+func (f *pacedFetcher) openStream(ctx context.Context, url string, offset int64) (io.ReadCloser, int64, error) {
+	var (
+		body  io.ReadCloser
+		first int64
+	)
+
+	err := f.retry(ctx, url, func() error {
+		var err error
+		body, first, err = f.fetcher.openStream(ctx, url, offset)
+
+		return err
+	})
+
+	return body, first, err
+}
@@ -0,0 +1,217 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		input string
+		want  time.Duration
+	}{
+		{"empty", "", 0},
+		{"seconds", "120", 2 * time.Minute},
+		{"negative", "-5", 0},
+		{"capped", "86400", paceMaxRetryAfter},
+		{"http date", "Sun, 01 Mar 2026 12:00:30 GMT", 30 * time.Second},
+		{"past date", "Sun, 01 Mar 2026 11:00:00 GMT", 0},
+		{"garbage", "soon", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.input, now); got != tt.want {
+				t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostPacerSpacesRequests(t *testing.T) {
+	p := newHostPacer(50*time.Millisecond, false)
+	ctx := context.Background()
+
+	start := time.Now()
+
+	for range 3 {
+		if err := p.wait(ctx, "https://a.example.com/file"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected two gaps of 50ms, elapsed: %v", elapsed)
+	}
+
+	// other hosts have their own turns
+	start = time.Now()
+	if err := p.wait(ctx, "https://b.example.com/file"); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+		t.Errorf("expected no wait for another host, elapsed: %v", elapsed)
+	}
+}
+
+func TestHostPacerRandomWait(t *testing.T) {
+	p := newHostPacer(time.Second, true)
+
+	for range 100 {
+		if got := p.interval(&hostPace{}); got < 500*time.Millisecond || got > 1500*time.Millisecond {
+			t.Fatalf("interval = %v, want between 0.5s and 1.5s", got)
+		}
+	}
+
+	if got := newHostPacer(0, true).interval(&hostPace{}); got != 0 {
+		t.Errorf("expected -random-wait alone to add nothing, got %v", got)
+	}
+}
+
+func TestHostPacerWaitCanceled(t *testing.T) {
+	p := newHostPacer(time.Hour, false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := p.wait(ctx, "https://a.example.com/"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.wait(ctx, "https://a.example.com/"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+
+	var none *hostPacer
+	if err := none.wait(ctx, "https://a.example.com/"); err != nil {
+		t.Errorf("expected a nil pacer to let everything through, got %v", err)
+	}
+}
+
+func TestHostPacerSlowDown(t *testing.T) {
+	p := newHostPacer(0, false)
+
+	p.slowDown("https://a.example.com/x", 0)
+	p.slowDown("https://a.example.com/y", 0)
+
+	_, h := p.host("https://a.example.com/")
+	if h.backoff != 2*paceMinBackoff {
+		t.Errorf("backoff = %v, want %v", h.backoff, 2*paceMinBackoff)
+	}
+
+	for range 10 {
+		p.slowDown("https://a.example.com/", 0)
+	}
+
+	if h.backoff != paceMaxBackoff {
+		t.Errorf("backoff = %v, want the cap %v", h.backoff, paceMaxBackoff)
+	}
+
+	p.slowDown("https://b.example.com/", 5*time.Minute)
+
+	_, h = p.host("https://b.example.com/")
+	if until := time.Until(h.next); until < 4*time.Minute {
+		t.Errorf("expected Retry-After to hold the host back, next request in %v", until)
+	}
+}
+
+func TestPacedFetcherSlowsDownOn429(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Retry-After", "3")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	app := &CLIApplication{Client: ts.Client(), pacer: newHostPacer(0, false)}
+
+	f, err := app.fetcherFor(ts.URL + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = f.probe(context.Background(), ts.URL+"/file")
+
+	var tooMany *tooManyRequestsError
+	if !errors.As(err, &tooMany) || tooMany.retryAfter != 3*time.Second {
+		t.Fatalf("expected a 429 error with Retry-After, got %v", err)
+	}
+
+	if !errors.Is(err, errHTTPStatusIsNotOK) {
+		t.Error("expected the 429 error to be an http status error")
+	}
+
+	_, h := app.pacer.host(ts.URL)
+	if h.backoff != paceMinBackoff || time.Until(h.next) < 2*time.Second {
+		t.Errorf("expected the host to be slowed down, got %+v", h)
+	}
+}
+
+// newFlakyServer answers the first request of each method with 429.
+func newFlakyServer(content []byte) *httptest.Server {
+	var mu sync.Mutex
+	refused := make(map[string]bool)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		first := !refused[r.Method]
+		refused[r.Method] = true
+		mu.Unlock()
+
+		if first {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+
+			return
+		}
+
+		w.Header().Set("Accept-Ranges", "bytes")
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+}
+
+func TestDownloadRetriesAfter429(t *testing.T) {
+	content := []byte("served after the host calmed down")
+	ts := newFlakyServer(content)
+	defer ts.Close()
+
+	dir := t.TempDir()
+	app := &CLIApplication{
+		Client:    ts.Client(),
+		chunkSize: 1,
+		limiter:   newRateLimiter(0),
+		outputDir: dir,
+		pacer:     newHostPacer(0, false),
+	}
+
+	// the probe and the download are each refused once
+	r, err := app.getResourceInformation(context.Background(), ts.URL+"/file.bin")
+	if err != nil {
+		t.Fatalf("probe should be retried after a 429: %v", err)
+	}
+
+	var downloaded atomic.Int64
+	if !app.fetchResource(context.Background(), r, &downloaded) {
+		t.Fatal("download should be retried after a 429")
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, r.filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(content) {
+		t.Errorf("content = %q, want %q", got, content)
+	}
+}
@@ -0,0 +1,263 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// probeWorkers bounds the probes in flight. The stages after probing take
+// one resource at a time, so this is all the read-ahead the pipeline does
+// and memory stays flat however long the input is.
+const probeWorkers = 16
+
+var errNoResources = errors.New("no valid resources found")
+
+// pipelineStats counts what went through the stages. The counters are
+// written by the stage goroutines, which may still run when a canceled
+// pipeline is checked, so they are atomic.
+type pipelineStats struct {
+	err    error // reading the piped input failed, guarded by mu
+	urls   atomic.Int64
+	probed atomic.Int64
+	piped  bool // set before the source stage starts
+	mu     sync.Mutex
+}
+
+func (s *pipelineStats) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.err = err
+}
+
+func (s *pipelineStats) readErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+// check reports a pipeline that had nothing to download.
+func (s *pipelineStats) check() error {
+	urls := s.urls.Load()
+
+	switch {
+	case s.readErr() != nil:
+		return s.readErr()
+	case urls == 0 && s.piped:
+		return errEmptyPipe
+	case urls == 0:
+		return errEmptyURL
+	case s.probed.Load() == 0:
+		return errNoResources
+	}
+
+	return nil
+}
+
+// sourceURLs streams the urls to download: the lines of pipe as they are
+// read, when pipe is set, then c.URLS. Every url is counted in queue.
+func (c *CLIApplication) sourceURLs(
+	ctx context.Context, pipe io.Reader, queue *jobQueue, stats *pipelineStats,
+) <-chan string {
+	out := make(chan string)
+	stats.piped = pipe != nil
+
+	go func() {
+		defer close(out)
+
+		emit := func(url string) bool {
+			queue.add(1)
+
+			select {
+			case out <- url:
+				stats.urls.Add(1)
+
+				return true
+			case <-ctx.Done():
+				queue.drop()
+
+				return false
+			}
+		}
+
+		if pipe != nil {
+			stats.setErr(scanURLs(pipe, func(line string) bool { return c.addLine(line, emit) }))
+		}
+
+		for _, url := range c.URLS {
+			if !emit(url) {
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// probeURLs probes up to probeWorkers urls at a time and passes the
+// resources on in input order, each as soon as it and the ones before it
+// are done. Failed probes are logged and left out.
+func (c *CLIApplication) probeURLs(
+	ctx context.Context, urls <-chan string, queue *jobQueue, stats *pipelineStats,
+) <-chan *resource {
+	out := make(chan *resource)
+	pending := make(chan chan *resource, probeWorkers)
+	slots := make(chan struct{}, probeWorkers)
+
+	go func() {
+		defer close(pending)
+
+		for url := range urls {
+			result := make(chan *resource, 1)
+
+			select {
+			case pending <- result:
+			case <-ctx.Done():
+				return
+			}
+
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				result <- nil
+
+				return
+			}
+
+			go func() {
+				defer func() { <-slots }()
+
+				result <- c.probe(ctx, url, queue)
+			}()
+		}
+	}()
+
+	go func() {
+		defer close(out)
+
+		for result := range pending {
+			r := <-result
+			if r == nil {
+				continue
+			}
+
+			stats.probed.Add(1)
+
+			select {
+			case out <- r:
+			case <-ctx.Done():
+				// nobody takes it anymore, e.g. -O - stopped at an error
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+func (c *CLIApplication) probe(ctx context.Context, url string, queue *jobQueue) *resource {
+	slog.Debug("fetching resource info", logKeyURL, url)
+
+	r, err := c.getResourceInformation(ctx, url)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("resource info failed", logKeyURL, url, logKeyError, err)
+		}
+		c.dropHint(url)
+		queue.drop()
+
+		return nil
+	}
+
+	return r
+}
+
+// runDownloads names every resource as it arrives, checks there is room
+// for it next to the downloads still running and hands it to the queue.
+func (c *CLIApplication) runDownloads(
+	ctx context.Context, resources <-chan *resource, queue *jobQueue, stats *pipelineStats,
+) error {
+	slog.Info("starting downloads", "chunks", c.chunkSize, "jobs", c.jobs, "output", c.outputDir)
+
+	pd := newProgressDisplay()
+	pd.status = func() string { return c.progressStatus(queue) }
+	pd.selectable = c.keyboard
+	pd.start()
+
+	c.display.Store(pd)
+	defer c.display.Store(nil)
+
+	ready := make(chan *resource)
+	results := make(chan downloadResult)
+
+	// bytes the running downloads are still going to write
+	var inFlight atomic.Int64
+
+	go func() {
+		defer close(ready)
+
+		names := newFileNames(c.outputDir)
+
+		for r := range resources {
+			names.claim(r)
+			c.dropHint(r.url)
+
+			size := max(r.length, 0)
+			if size > 0 {
+				if err := checkDiskSpace(c.outputDir, inFlight.Load()+size); err != nil {
+					slog.Error("skipping download", logKeyURL, r.url, logKeyError, err)
+					queue.drop()
+					results <- downloadResult{}
+
+					continue
+				}
+			}
+
+			inFlight.Add(size)
+			ready <- r
+		}
+	}()
+
+	go func() {
+		defer close(results)
+
+		queue.run(ready, func(r *resource) {
+			c.download(ctx, r, results, pd)
+			inFlight.Add(-max(r.length, 0))
+		})
+	}()
+
+	var (
+		count, failCount int
+		completedSize    int64
+	)
+
+	for result := range results {
+		count++
+		if !result.ok && !result.canceled {
+			failCount++
+		}
+
+		completedSize += result.size
+	}
+
+	pd.finish()
+
+	if err := stats.check(); err != nil {
+		return err
+	}
+
+	if failCount > 0 {
+		return fmt.Errorf("%d download(s) failed", failCount)
+	}
+
+	slog.Info("all downloads complete", "count", count, "total_size", formatBytes(completedSize))
+
+	return nil
+}
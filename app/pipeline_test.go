@@ -0,0 +1,246 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProbeURLsKeepsInputOrder(t *testing.T) {
+	var inFlight, peak atomic.Int32
+
+	// earlier files answer slower, so probes finish in reverse order
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+
+		i, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		time.Sleep(time.Duration(40-i) * time.Millisecond)
+
+		w.Header().Set("Content-Length", "1")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	app := &CLIApplication{Client: ts.Client(), chunkSize: 1}
+	for i := range 40 {
+		app.URLS = append(app.URLS, fmt.Sprintf("%s/%d", ts.URL, i))
+	}
+
+	queue := newJobQueue(0, orderInput)
+	stats := &pipelineStats{}
+	ctx := context.Background()
+
+	var got []string
+	for r := range app.probeURLs(ctx, app.sourceURLs(ctx, nil, queue, stats), queue, stats) {
+		got = append(got, r.url)
+	}
+
+	if len(got) != len(app.URLS) {
+		t.Fatalf("got %d resources, want %d", len(got), len(app.URLS))
+	}
+
+	for i := range got {
+		if got[i] != app.URLS[i] {
+			t.Fatalf("resources[%d] = %s, want %s", i, got[i], app.URLS[i])
+		}
+	}
+
+	if p := peak.Load(); p > probeWorkers {
+		t.Errorf("peak probes = %d, want at most %d", p, probeWorkers)
+	}
+
+	if stats.urls.Load() != 40 || stats.probed.Load() != 40 {
+		t.Errorf("stats = %+v, want 40 urls and 40 probed", stats)
+	}
+}
+
+func TestSourceURLsStreamsPipe(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer func() { _ = pw.Close() }()
+
+	app := &CLIApplication{URLS: []string{"https://example.com/last"}}
+	queue := newJobQueue(0, orderInput)
+	stats := &pipelineStats{}
+
+	urls := app.sourceURLs(context.Background(), pr, queue, stats)
+
+	if _, err := io.WriteString(pw, "https://example.com/[1-2]\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first lines arrive while the input is still open
+	for _, want := range []string{"https://example.com/1", "https://example.com/2"} {
+		select {
+		case got := <-urls:
+			if got != want {
+				t.Errorf("url = %s, want %s", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected piped urls before the end of input")
+		}
+	}
+
+	_ = pw.Close()
+
+	var rest []string
+	for url := range urls {
+		rest = append(rest, url)
+	}
+
+	if len(rest) != 1 || rest[0] != "https://example.com/last" {
+		t.Errorf("expected the argument urls after the pipe, got %v", rest)
+	}
+
+	if queued, _, _ := queue.counts(); queued != 3 {
+		t.Errorf("queued = %d, want 3", queued)
+	}
+}
+
+func TestSourceURLsStopsOnCancel(t *testing.T) {
+	app := &CLIApplication{URLS: []string{"https://example.com/a", "https://example.com/b"}}
+	queue := newJobQueue(0, orderInput)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	stats := &pipelineStats{}
+	for range app.sourceURLs(ctx, nil, queue, stats) {
+	}
+
+	// urls that never left the source are not counted
+	if queued, _, _ := queue.counts(); int64(queued) != stats.urls.Load() {
+		t.Errorf("queued = %d, want %d", queued, stats.urls.Load())
+	}
+}
+
+func TestProbeURLsStopsOnCancel(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	app := &CLIApplication{Client: ts.Client(), chunkSize: 1}
+	for i := range 3 * probeWorkers {
+		url := fmt.Sprintf("%s/%d", ts.URL, i)
+		app.URLS = append(app.URLS, url)
+		app.setHint(url, urlHint{priority: i + 1})
+	}
+
+	queue := newJobQueue(0, orderInput)
+	stats := &pipelineStats{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	resources := app.probeURLs(ctx, app.sourceURLs(ctx, nil, queue, stats), queue, stats)
+
+	// like -O - at a write error: take one resource, then stop reading
+	first := <-resources
+	cancel()
+
+	// checked while the source may still be running, as runDownloads does
+	_ = stats.check()
+
+	done := make(chan struct{})
+	go func() {
+		for range resources {
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the probe stage should stop once the pipeline is canceled")
+	}
+
+	if first.priority != 1 {
+		t.Errorf("priority = %d, want the hint of the first url", first.priority)
+	}
+}
+
+func TestPipelineStatsCheck(t *testing.T) {
+	readErr := errors.New("read error")
+
+	tests := []struct {
+		name         string
+		err          error
+		urls, probed int64
+		piped        bool
+		want         error
+	}{
+		{name: "ok", urls: 2, probed: 1},
+		{name: "read error", err: readErr, urls: 2, probed: 2, want: readErr},
+		{name: "empty pipe", piped: true, want: errEmptyPipe},
+		{name: "no urls", want: errEmptyURL},
+		{name: "nothing probed", urls: 3, want: errNoResources},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := &pipelineStats{err: tt.err, piped: tt.piped}
+			stats.urls.Store(tt.urls)
+			stats.probed.Store(tt.probed)
+
+			if err := stats.check(); !errors.Is(err, tt.want) {
+				t.Errorf("check() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunDownloadsNamesAsTheyArrive(t *testing.T) {
+	ts := newTestServer([]byte("content"), false)
+	defer ts.Close()
+
+	dir := t.TempDir()
+
+	app := &CLIApplication{Client: ts.Client(), chunkSize: 1, outputDir: dir, jobs: 1}
+
+	// the same name twice, plus a url whose probe fails
+	app.URLS = []string{ts.URL + "/a/file.bin", ts.URL + "/b/file.bin", "ftp://127.0.0.1:1/missing.bin"}
+	for _, url := range app.URLS {
+		app.setHint(url, urlHint{priority: 1})
+	}
+
+	queue := newJobQueue(app.jobs, orderInput)
+	stats := &pipelineStats{}
+	ctx := context.Background()
+	resources := app.probeURLs(ctx, app.sourceURLs(ctx, nil, queue, stats), queue, stats)
+
+	if err := app.runDownloads(ctx, resources, queue, stats); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"file.bin", "file_1.bin"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %s to exist: %v", name, err)
+		}
+	}
+
+	if got, want := queue.status(), "files: 0 queued, 0 active, 2 done"; got != want {
+		t.Errorf("status = %q, want %q", got, want)
+	}
+
+	// the hints are not needed once the files are named
+	if len(app.hints) != 0 {
+		t.Errorf("hints = %v, want none left", app.hints)
+	}
+}
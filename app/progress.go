@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -26,18 +27,39 @@ func (cr *countingReader) Read(p []byte) (int, error) {
 	return n, err
 }
 
+type countingWriter struct {
+	writer  io.Writer
+	counter *atomic.Int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.writer.Write(p)
+	if n > 0 {
+		cw.counter.Add(int64(n))
+	}
+
+	return n, err
+}
+
 // progressDisplay manages multi-line progress output for concurrent downloads.
 type progressDisplay struct {
 	mu      sync.Mutex
 	entries []progressEntry
+	status  func() string // optional line below the bars
 	lines   int
 	stop    chan struct{}
 	done    chan struct{}
+
+	// with keyboard control, one line is selected and its transfer is
+	// what the keys act on
+	selectable bool
+	selected   int
 }
 
 type progressEntry struct {
 	filename string
 	current  *atomic.Int64
+	control  *transferControl // nil if the keys cannot pause it
 	total    int64
 }
 
@@ -49,16 +71,78 @@ func newProgressDisplay() *progressDisplay {
 }
 
 func (pd *progressDisplay) add(filename string, current *atomic.Int64, total int64) {
+	pd.addTransfer(filename, current, total, nil)
+}
+
+// addTransfer adds a line whose download the keyboard can pause, resume and
+// cancel through control.
+func (pd *progressDisplay) addTransfer(filename string, current *atomic.Int64, total int64, control *transferControl) {
 	pd.mu.Lock()
 	defer pd.mu.Unlock()
 
 	pd.entries = append(pd.entries, progressEntry{
 		filename: filename,
 		current:  current,
+		control:  control,
 		total:    total,
 	})
 }
 
+// moveSelection selects the line delta lines below the selected one.
+func (pd *progressDisplay) moveSelection(delta int) {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+
+	pd.selected = min(max(pd.selected+delta, 0), max(len(pd.entries)-1, 0))
+}
+
+// selectedControl returns the control of the selected line, or nil.
+func (pd *progressDisplay) selectedControl() *transferControl {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+
+	if len(pd.entries) == 0 {
+		return nil
+	}
+
+	return pd.entries[min(pd.selected, len(pd.entries)-1)].control
+}
+
+// controls returns the controls of all lines that have one.
+func (pd *progressDisplay) controls() []*transferControl {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+
+	var controls []*transferControl
+	for _, e := range pd.entries {
+		if e.control != nil {
+			controls = append(controls, e.control)
+		}
+	}
+
+	return controls
+}
+
+// remove drops the entry counting into current. The selection stays on
+// the same line, the keys must not act on another download than the
+// highlighted one.
+func (pd *progressDisplay) remove(current *atomic.Int64) {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+
+	i := slices.IndexFunc(pd.entries, func(e progressEntry) bool { return e.current == current })
+	if i < 0 {
+		return
+	}
+
+	pd.entries = slices.Delete(pd.entries, i, i+1)
+
+	if i < pd.selected {
+		pd.selected--
+	}
+	pd.selected = min(pd.selected, max(len(pd.entries)-1, 0))
+}
+
 func (pd *progressDisplay) start() {
 	go func() {
 		defer close(pd.done)
@@ -104,6 +188,14 @@ func (pd *progressDisplay) render() {
 
 	for i, e := range pd.entries {
 		bars[i] = formatProgressBar(e.current.Load(), e.total)
+		if e.control != nil {
+			switch e.control.current() {
+			case transferPaused:
+				bars[i] += " paused"
+			case transferCanceled:
+				bars[i] += " canceled"
+			}
+		}
 		if len(bars[i]) > maxBarLen {
 			maxBarLen = len(bars[i])
 		}
@@ -116,7 +208,14 @@ func (pd *progressDisplay) render() {
 	const separatorLen = 2
 	const minNameWidth = 10
 
-	availableForName := termWidth - maxBarLen - separatorLen
+	// "> " marks the selected line
+	markerLen := 0
+	if pd.selectable {
+		markerLen = 2
+		pd.selected = min(pd.selected, max(len(pd.entries)-1, 0))
+	}
+
+	availableForName := termWidth - maxBarLen - separatorLen - markerLen
 	if availableForName < minNameWidth {
 		availableForName = minNameWidth
 	}
@@ -125,11 +224,35 @@ func (pd *progressDisplay) render() {
 	}
 
 	for i, e := range pd.entries {
+		marker := ""
+		if pd.selectable {
+			marker = "  "
+			if i == pd.selected {
+				marker = "> "
+			}
+		}
+
 		name := truncateFilename(e.filename, maxNameLen)
-		fmt.Fprintf(os.Stderr, "\r\033[K%*s: %s\n", maxNameLen, name, bars[i])
+		fmt.Fprintf(os.Stderr, "\r\033[K%s%*s: %s\n", marker, maxNameLen, name, bars[i])
+	}
+
+	lines := len(pd.entries)
+
+	if pd.status != nil {
+		if status := pd.status(); status != "" {
+			for line := range strings.SplitSeq(status, "\n") {
+				fmt.Fprintf(os.Stderr, "\r\033[K%s\n", line)
+				lines++
+			}
+		}
+	}
+
+	// clear what is left of a longer previous frame
+	if lines < pd.lines {
+		fmt.Fprint(os.Stderr, "\033[J")
 	}
 
-	pd.lines = len(pd.entries)
+	pd.lines = lines
 }
 
 func truncateFilename(name string, maxWidth int) string {
@@ -133,3 +133,41 @@ func TestProgressDisplayMultipleEntries(t *testing.T) {
 	time.Sleep(500 * time.Millisecond)
 	pd.finish()
 }
+
+func TestProgressDisplayRemove(t *testing.T) {
+	pd := newProgressDisplay()
+
+	var a, b atomic.Int64
+	pd.add("a.bin", &a, 10)
+	pd.add("b.bin", &b, 10)
+
+	pd.remove(&a)
+
+	if len(pd.entries) != 1 || pd.entries[0].filename != "b.bin" {
+		t.Errorf("expected only b.bin to be left, got %+v", pd.entries)
+	}
+}
+
+func TestProgressDisplayRemoveKeepsSelection(t *testing.T) {
+	pd := newProgressDisplay()
+
+	var a, b, c atomic.Int64
+	cb, cc := newTransferControl(), newTransferControl()
+	pd.addTransfer("a.bin", &a, 10, newTransferControl())
+	pd.addTransfer("b.bin", &b, 10, cb)
+	pd.addTransfer("c.bin", &c, 10, cc)
+
+	pd.moveSelection(1)
+
+	// a line above the selection finishes
+	pd.remove(&a)
+	if got := pd.selectedControl(); got != cb {
+		t.Error("the selection should stay on b.bin when a line above it goes")
+	}
+
+	// a line below it does not move it either
+	pd.remove(&c)
+	if got := pd.selectedControl(); got != cb {
+		t.Error("the selection should stay on b.bin when a line below it goes")
+	}
+}
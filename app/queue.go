@@ -0,0 +1,94 @@
+package app
+
+import (
+	"fmt"
+	"sync"
+)
+
+// jobQueue runs downloads on a fixed number of workers and counts them for
+// the progress display.
+type jobQueue struct {
+	jobs   int
+	order  orderPolicy
+	queued int
+	active int
+	done   int
+	mu     sync.Mutex
+}
+
+// newJobQueue returns a queue running at most jobs downloads at a time,
+// jobs <= 0 runs every download at once. order picks the next download
+// when a worker frees up.
+func newJobQueue(jobs int, order orderPolicy) *jobQueue {
+	return &jobQueue{jobs: jobs, order: order}
+}
+
+// add counts n urls that will reach the queue later.
+func (q *jobQueue) add(n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.queued += n
+}
+
+// drop forgets a queued url that won't be downloaded, e.g. because its
+// probe failed.
+func (q *jobQueue) drop() {
+	q.add(-1)
+}
+
+// run calls fn for every resource from in, at most q.jobs at a time, in
+// the order of q.order. It returns when in is closed and every call has
+// returned.
+func (q *jobQueue) run(in <-chan *resource, fn func(*resource)) {
+	var wg sync.WaitGroup
+
+	in = q.order.reorder(in)
+
+	work := func(r *resource) {
+		q.move(&q.queued, &q.active)
+		fn(r)
+		q.move(&q.active, &q.done)
+	}
+
+	if q.jobs <= 0 {
+		for r := range in {
+			wg.Go(func() { work(r) })
+		}
+	} else {
+		for range q.jobs {
+			wg.Go(func() {
+				for r := range in {
+					work(r)
+				}
+			})
+		}
+	}
+
+	wg.Wait()
+}
+
+// move shifts one job between two counters in a single step, so the
+// counts always add up.
+func (q *jobQueue) move(from, to *int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	*from--
+	*to++
+}
+
+// counts returns the queued, active and done jobs.
+func (q *jobQueue) counts() (int, int, int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.queued, q.active, q.done
+}
+
+// status is the progress display line with the queue counts.
+func (q *jobQueue) status() string {
+	queued, active, done := q.counts()
+
+	return fmt.Sprintf("files: %d queued, %d active, %d done", queued, active, done)
+}
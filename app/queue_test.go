@@ -0,0 +1,119 @@
+package app
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// feedQueue counts n resources in q and sends them on the returned channel.
+func feedQueue(q *jobQueue, n int) <-chan *resource {
+	q.add(n)
+
+	in := make(chan *resource)
+
+	go func() {
+		defer close(in)
+
+		for range n {
+			in <- &resource{}
+		}
+	}()
+
+	return in
+}
+
+func TestJobQueueLimitsActive(t *testing.T) {
+	q := newJobQueue(2, orderInput)
+
+	var running, peak, calls atomic.Int32
+
+	q.run(feedQueue(q, 6), func(*resource) {
+		calls.Add(1)
+
+		n := running.Add(1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		running.Add(-1)
+	})
+
+	if got := peak.Load(); got != 2 {
+		t.Errorf("peak active = %d, want 2", got)
+	}
+
+	if got := calls.Load(); got != 6 {
+		t.Errorf("expected every job to run, got %d", got)
+	}
+
+	if queued, active, done := q.counts(); queued != 0 || active != 0 || done != 6 {
+		t.Errorf("counts = %d/%d/%d, want 0/0/6", queued, active, done)
+	}
+}
+
+func TestJobQueueUnlimited(t *testing.T) {
+	q := newJobQueue(0, orderInput)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		q.run(feedQueue(q, 5), func(*resource) {
+			started <- struct{}{}
+			<-release
+		})
+		close(finished)
+	}()
+
+	// every job is active at once
+	for range 5 {
+		<-started
+	}
+
+	if queued, active, done := q.counts(); queued != 0 || active != 5 || done != 0 {
+		t.Errorf("counts = %d/%d/%d, want 0/5/0", queued, active, done)
+	}
+
+	close(release)
+	<-finished
+}
+
+func TestJobQueueStatus(t *testing.T) {
+	q := newJobQueue(1, orderInput)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		q.run(feedQueue(q, 3), func(*resource) {
+			started <- struct{}{}
+			<-release
+		})
+		close(finished)
+	}()
+
+	<-started
+
+	if got, want := q.status(), "files: 2 queued, 1 active, 0 done"; got != want {
+		t.Errorf("status = %q, want %q", got, want)
+	}
+
+	close(release)
+	<-started
+	<-started
+	<-finished
+
+	q.drop()
+	q.add(1)
+
+	if got, want := q.status(), "files: 0 queued, 0 active, 3 done"; got != want {
+		t.Errorf("status = %q, want %q", got, want)
+	}
+}
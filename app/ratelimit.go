@@ -1,88 +1,240 @@
 package app
 
 import (
+	"context"
 	"io"
+	neturl "net/url"
 	"sync"
 	"time"
 )
 
-// rateLimiter implements a token bucket for bandwidth limiting.
+// rateLimiter is a token bucket shared by concurrent readers. Callers
+// reserve bytes and sleep on a timer until the bucket has produced them;
+// reservations are served in the order they were made, so no reader can
+// starve another. The bucket holds at most burst bytes while idle.
+//
+// Tokens are tracked as two running totals: minted grows with the rate,
+// reserved with every request. A request is served once minted catches up
+// with the total reserved up to and including it.
 type rateLimiter struct {
-	mu         sync.Mutex
-	rate       int64 // bytes per second, 0 = unlimited
-	tokens     int64
-	lastRefill time.Time
+	last     time.Time
+	changed  chan struct{} // closed when the rate changes
+	rate     int64         // bytes per second, 0 = unlimited
+	burst    int64         // 0 means one second worth of rate
+	minted   float64
+	reserved float64
+	mu       sync.Mutex
 }
 
 func newRateLimiter(bytesPerSecond int64) *rateLimiter {
-	return &rateLimiter{
-		rate:       bytesPerSecond,
-		tokens:     bytesPerSecond,
-		lastRefill: time.Now(),
+	rl := &rateLimiter{
+		rate:    bytesPerSecond,
+		last:    time.Now(),
+		changed: make(chan struct{}),
 	}
+	rl.minted = float64(rl.capacity())
+
+	return rl
 }
 
-func (rl *rateLimiter) wait(n int) {
-	if rl.rate == 0 {
-		return
+// capacity is the number of bytes the bucket may hold. Callers hold mu.
+func (rl *rateLimiter) capacity() int64 {
+	if rl.burst > 0 {
+		return rl.burst
 	}
 
-	remaining := int64(n)
+	return max(rl.rate, 1)
+}
 
-	for remaining > 0 {
-		// request at most rl.rate tokens at a time to avoid deadlock
-		request := remaining
-		if request > rl.rate {
-			request = rl.rate
-		}
+// advance mints the tokens produced since the last call. Callers hold mu.
+func (rl *rateLimiter) advance(now time.Time) {
+	if elapsed := now.Sub(rl.last); elapsed > 0 {
+		rl.minted += elapsed.Seconds() * float64(rl.rate)
+		rl.last = now
+	}
 
-		rl.waitForTokens(request)
-		remaining -= request
+	if limit := rl.reserved + float64(rl.capacity()); rl.minted > limit {
+		rl.minted = limit
 	}
 }
 
-func (rl *rateLimiter) waitForTokens(n int64) {
-	for {
-		rl.mu.Lock()
+// wait blocks until n bytes may pass or ctx is done.
+func (rl *rateLimiter) wait(ctx context.Context, n int) error {
+	rl.mu.Lock()
 
-		now := time.Now()
-		elapsed := now.Sub(rl.lastRefill)
-		newTokens := int64(elapsed.Seconds() * float64(rl.rate))
+	if rl.rate == 0 {
+		rl.mu.Unlock()
 
-		if newTokens > 0 {
-			rl.tokens += newTokens
-			if rl.tokens > rl.rate {
-				rl.tokens = rl.rate
-			}
-			rl.lastRefill = now
-		}
+		return nil
+	}
+
+	rl.advance(time.Now())
+	rl.reserved += float64(n)
+	ticket := rl.reserved
 
-		if rl.tokens >= n {
-			rl.tokens -= n
+	for {
+		if rl.rate == 0 || rl.minted >= ticket {
 			rl.mu.Unlock()
 
-			return
+			return nil
 		}
 
+		delay := time.Duration((ticket - rl.minted) / float64(rl.rate) * float64(time.Second))
+		changed := rl.changed
 		rl.mu.Unlock()
-		time.Sleep(10 * time.Millisecond)
+
+		timer := time.NewTimer(max(delay, time.Microsecond))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			// hand the unserved bytes to the readers queued behind
+			rl.mu.Lock()
+			rl.minted += float64(n)
+			rl.mu.Unlock()
+
+			return ctx.Err()
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+
+		rl.mu.Lock()
+		rl.advance(time.Now())
+	}
+}
+
+// limit returns the current rate in bytes per second, 0 means unlimited.
+func (rl *rateLimiter) limit() int64 {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	return rl.rate
+}
+
+// readSize is the largest read that should be charged at once.
+func (rl *rateLimiter) readSize() int64 {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.rate == 0 {
+		return 0
+	}
+
+	return rl.capacity()
+}
+
+// setRate changes the rate while downloads are running. Sleeping readers
+// wake up and recompute their delay with the new rate.
+func (rl *rateLimiter) setRate(bytesPerSecond int64) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.advance(time.Now())
+
+	wasUnlimited := rl.rate == 0
+	rl.rate = bytesPerSecond
+	rl.advance(time.Now())
+
+	if wasUnlimited {
+		// nothing was reserved while unlimited, start with a full bucket
+		rl.minted = rl.reserved + float64(rl.capacity())
 	}
+
+	close(rl.changed)
+	rl.changed = make(chan struct{})
 }
 
+// setBurst sets the bucket size, 0 means one second worth of rate.
+func (rl *rateLimiter) setBurst(n int64) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.burst = n
+}
+
+// hostLimiters hands out one bucket per host. A zero rate means no
+// per-host limit.
+type hostLimiters struct {
+	buckets map[string]*rateLimiter
+	rate    int64
+	burst   int64
+	mu      sync.Mutex
+}
+
+func newHostLimiters(bytesPerSecond, burst int64) *hostLimiters {
+	return &hostLimiters{
+		buckets: make(map[string]*rateLimiter),
+		rate:    bytesPerSecond,
+		burst:   burst,
+	}
+}
+
+func (h *hostLimiters) get(url string) *rateLimiter {
+	if h == nil || h.rate <= 0 {
+		return nil
+	}
+
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	rl, ok := h.buckets[u.Hostname()]
+	if !ok {
+		rl = newRateLimiter(h.rate)
+		rl.setBurst(h.burst)
+		h.buckets[u.Hostname()] = rl
+	}
+
+	return rl
+}
+
+// throttle wraps reader with every bucket that applies to url: the global
+// limit, the limit of its host and the limit of the file being downloaded.
+func (c *CLIApplication) throttle(ctx context.Context, reader io.Reader, url string, file *rateLimiter) io.Reader {
+	var limiters []*rateLimiter
+
+	for _, rl := range []*rateLimiter{c.limiter, c.hostLimits.get(url), file} {
+		if rl != nil {
+			limiters = append(limiters, rl)
+		}
+	}
+
+	if len(limiters) == 0 {
+		return reader
+	}
+
+	return &rateLimitedReader{ctx: ctx, reader: reader, limiters: limiters}
+}
+
+// rateLimitedReader takes tokens from every limiter for each read, so the
+// strictest bucket sets the pace.
 type rateLimitedReader struct {
-	reader  io.Reader
-	limiter *rateLimiter
+	ctx      context.Context
+	reader   io.Reader
+	limiters []*rateLimiter
 }
 
 func (r *rateLimitedReader) Read(p []byte) (int, error) {
-	// cap read size to rate limit to avoid large bursts
-	if r.limiter.rate > 0 && int64(len(p)) > r.limiter.rate {
-		p = p[:r.limiter.rate]
+	// cap read size to the smallest bucket to avoid large bursts
+	for _, rl := range r.limiters {
+		if size := rl.readSize(); size > 0 && int64(len(p)) > size {
+			p = p[:size]
+		}
 	}
 
 	n, err := r.reader.Read(p)
 	if n > 0 {
-		r.limiter.wait(n)
+		for _, rl := range r.limiters {
+			if werr := rl.wait(r.ctx, n); werr != nil {
+				return n, werr
+			}
+		}
 	}
 
 	return n, err
@@ -1,8 +1,11 @@
 package app
 
 import (
+	"context"
+	"errors"
 	"io"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -12,7 +15,7 @@ func TestRateLimitedReader(t *testing.T) {
 	r := strings.NewReader(data)
 
 	limiter := newRateLimiter(5120) // 5KB/s
-	lr := &rateLimitedReader{reader: r, limiter: limiter}
+	lr := &rateLimitedReader{ctx: context.Background(), reader: r, limiters: []*rateLimiter{limiter}}
 
 	start := time.Now()
 	buf, err := io.ReadAll(lr)
@@ -34,7 +37,7 @@ func TestRateLimiterZeroMeansUnlimited(t *testing.T) {
 	r := strings.NewReader(data)
 
 	limiter := newRateLimiter(0)
-	lr := &rateLimitedReader{reader: r, limiter: limiter}
+	lr := &rateLimitedReader{ctx: context.Background(), reader: r, limiters: []*rateLimiter{limiter}}
 
 	buf, err := io.ReadAll(lr)
 	if err != nil {
@@ -50,7 +53,7 @@ func TestRateLimitedReaderLargeBuffer(t *testing.T) {
 	r := strings.NewReader(data)
 
 	limiter := newRateLimiter(50) // 50 bytes/sec
-	lr := &rateLimitedReader{reader: r, limiter: limiter}
+	lr := &rateLimitedReader{ctx: context.Background(), reader: r, limiters: []*rateLimiter{limiter}}
 
 	// use buffer larger than rate to trigger cap in Read
 	buf := make([]byte, 200)
@@ -67,5 +70,210 @@ func TestRateLimitedReaderLargeBuffer(t *testing.T) {
 func TestRateLimiterWaitLargeN(t *testing.T) {
 	limiter := newRateLimiter(100)
 	// wait for 250 bytes — triggers loop splitting into chunks of rl.rate
-	limiter.wait(250)
+	if err := limiter.wait(context.Background(), 250); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHostLimiters(t *testing.T) {
+	hl := newHostLimiters(1000, 0)
+
+	a := hl.get("https://a.example.com/x")
+	if a == nil || a != hl.get("https://a.example.com:443/y") {
+		t.Error("expected one bucket per host")
+	}
+
+	if a == hl.get("https://b.example.com/x") {
+		t.Error("expected separate buckets for separate hosts")
+	}
+
+	if newHostLimiters(0, 0).get("https://a.example.com/") != nil {
+		t.Error("expected no bucket without a per-host limit")
+	}
+
+	var none *hostLimiters
+	if none.get("https://a.example.com/") != nil {
+		t.Error("expected nil hostLimiters to be usable")
+	}
+}
+
+func TestThrottleUsesStrictestBucket(t *testing.T) {
+	app := &CLIApplication{limiter: newRateLimiter(0), hostLimits: newHostLimiters(100*kilo, 0)}
+
+	ctx := context.Background()
+
+	if _, ok := (&CLIApplication{}).throttle(ctx, strings.NewReader(""), "https://a/", nil).(*strings.Reader); !ok {
+		t.Error("expected the reader to be left alone without limits")
+	}
+
+	data := strings.NewReader(strings.Repeat("x", 10240))
+	reader := app.throttle(ctx, data, "https://a.example.com/f", newRateLimiter(5120))
+
+	lr, ok := reader.(*rateLimitedReader)
+	if !ok || len(lr.limiters) != 3 {
+		t.Fatalf("expected global, host and file buckets, got %T", reader)
+	}
+
+	start := time.Now()
+	if _, err := io.ReadAll(reader); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("expected the 5KB/s file limit to apply, elapsed: %v", elapsed)
+	}
+}
+
+func TestRateLimiterWaitCanceled(t *testing.T) {
+	limiter := newRateLimiter(1000)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	// the first 1000 bytes are the initial burst, the next would take 10s
+	if err := limiter.wait(ctx, 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	err := limiter.wait(ctx, 10000)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected cancel to end the wait promptly, elapsed: %v", elapsed)
+	}
+}
+
+func TestRateLimiterFIFO(t *testing.T) {
+	limiter := newRateLimiter(10 * kilo)
+	if err := limiter.wait(context.Background(), 10*kilo); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu    sync.Mutex
+		order []int
+		wg    sync.WaitGroup
+	)
+
+	for i := range 5 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_ = limiter.wait(context.Background(), kilo)
+
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+		}()
+
+		// let each waiter queue before the next one
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	wg.Wait()
+
+	for i, got := range order {
+		if got != i {
+			t.Fatalf("expected waiters to be served in order, got %v", order)
+		}
+	}
+}
+
+func TestRateLimiterAccuracy(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  int64
+		total int64
+		want  time.Duration
+	}{
+		{name: "slow", rate: kilo, total: 2 * kilo, want: time.Second},
+		{name: "fast", rate: 2 * giga, total: 4 * giga, want: time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newRateLimiter(tt.rate)
+			chunk := limiter.readSize()
+
+			start := time.Now()
+
+			for sent := int64(0); sent < tt.total; sent += chunk {
+				if err := limiter.wait(context.Background(), int(min(chunk, tt.total-sent))); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// the first second worth is the initial burst
+			elapsed := time.Since(start)
+			if elapsed < tt.want-50*time.Millisecond || elapsed > tt.want+250*time.Millisecond {
+				t.Errorf("expected about %v, elapsed: %v", tt.want, elapsed)
+			}
+		})
+	}
+}
+
+func TestRateLimiterBurst(t *testing.T) {
+	limiter := newRateLimiter(kilo)
+	limiter.setBurst(4 * kilo)
+
+	if got := limiter.readSize(); got != 4*kilo {
+		t.Errorf("readSize = %d, want %d", got, 4*kilo)
+	}
+
+	// let the bucket fill up to the burst
+	limiter.setRate(100 * kilo)
+	time.Sleep(50 * time.Millisecond)
+	limiter.setRate(kilo)
+
+	start := time.Now()
+	if err := limiter.wait(context.Background(), 4*kilo); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("expected the burst to pass at once, elapsed: %v", elapsed)
+	}
+}
+
+func TestRateLimiterSetRateWakesWaiters(t *testing.T) {
+	limiter := newRateLimiter(100)
+	if err := limiter.wait(context.Background(), 100); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+
+	go func() { done <- limiter.wait(context.Background(), 10000) }()
+
+	time.Sleep(20 * time.Millisecond)
+	limiter.setRate(0)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the waiter to wake up when the limit was lifted")
+	}
+}
+
+func TestRateLimiterSetRateFromUnlimited(t *testing.T) {
+	limiter := newRateLimiter(0)
+	limiter.setRate(1000)
+
+	// the limit starts with a full bucket, the first second passes at once
+	start := time.Now()
+	if err := limiter.wait(context.Background(), 1000); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("first wait after setting a limit took %v, want no delay", elapsed)
+	}
 }
@@ -1,8 +1,18 @@
 package app
 
 import (
+	"encoding/json"
 	"fmt"
+	"log/slog"
 	"os"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	chunkStateSuffix   = ".state"
+	chunkStateInterval = time.Second
 )
 
 // getResumeOffset returns the size of the .part file, or 0 if it doesn't exist.
@@ -21,3 +31,176 @@ func finalizePart(partPath, finalPath string) error {
 	}
 	return nil
 }
+
+// chunkState records how many bytes of each chunk of a .part file are
+// written, so an interrupted chunked download continues where it stopped
+// instead of starting over. It is saved next to the part file.
+type chunkState struct {
+	Chunks [][2]int64 `json:"chunks"`
+	Done   []int64    `json:"done"`
+	Length int64      `json:"length"`
+}
+
+// loadChunkState returns the bytes already written per chunk of r. A
+// missing state, or one for another chunk layout, starts every chunk over.
+func loadChunkState(path string, r *resource) []int64 {
+	done := make([]int64, len(r.chunks))
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return done
+	}
+
+	var st chunkState
+	if err := json.Unmarshal(data, &st); err != nil {
+		slog.Debug("ignoring chunk state", "path", path, logKeyError, err)
+
+		return done
+	}
+
+	if st.Length != r.length || !slices.Equal(st.Chunks, r.chunks) || len(st.Done) != len(done) {
+		return done
+	}
+
+	for i, n := range st.Done {
+		done[i] = min(max(n, 0), r.chunks[i][1]-r.chunks[i][0]+1)
+	}
+
+	return done
+}
+
+func saveChunkState(path string, r *resource, done []int64) error {
+	data, err := json.Marshal(chunkState{Chunks: r.chunks, Done: done, Length: r.length})
+	if err != nil {
+		return fmt.Errorf("failed to encode chunk state: %w", err)
+	}
+
+	return writeFileAtomic(path, data)
+}
+
+// hlsState records how many segments of an HLS .part file are written and
+// how long the file is after them, so a stopped HLS download continues with
+// the next segment. It shares the state file name of the chunk state.
+type hlsState struct {
+	Segments int   `json:"segments"`
+	Done     int   `json:"done"`
+	Size     int64 `json:"size"`
+}
+
+// loadHLSState returns the state saved for a playlist of the given number
+// of segments, or a zero state to start over if there is none, it is for
+// another playlist or the part file is shorter than it claims.
+func loadHLSState(path, partPath string, segments int) hlsState {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return hlsState{}
+	}
+
+	var st hlsState
+	if err := json.Unmarshal(data, &st); err != nil {
+		slog.Debug("ignoring hls state", "path", path, logKeyError, err)
+
+		return hlsState{}
+	}
+
+	if st.Segments != segments || st.Done < 0 || st.Done > segments || st.Size < 0 || getResumeOffset(partPath) < st.Size {
+		return hlsState{}
+	}
+
+	return st
+}
+
+func saveHLSState(path string, st hlsState) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("failed to encode hls state: %w", err)
+	}
+
+	return writeFileAtomic(path, data)
+}
+
+// trackChunks sums the chunk counters into downloaded and saves the chunk
+// state of partPath every chunkStateInterval. The counters must only count
+// bytes already written. The returned function stops tracking after a last
+// update.
+func trackChunks(partPath string, r *resource, counters []atomic.Int64, downloaded *atomic.Int64) func() {
+	statePath := partPath + chunkStateSuffix
+
+	update := func() {
+		sumCounters(counters, downloaded)
+
+		done := make([]int64, len(counters))
+		for i := range counters {
+			done[i] = counters[i].Load()
+		}
+
+		// the state must never claim bytes a crash could still lose
+		if err := syncFile(partPath); err != nil {
+			slog.Debug("failed to sync part file", "path", partPath, logKeyError, err)
+
+			return
+		}
+
+		if err := saveChunkState(statePath, r, done); err != nil {
+			slog.Debug("failed to save chunk state", "path", statePath, logKeyError, err)
+		}
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(progressUpdateInterval)
+		defer ticker.Stop()
+
+		lastSave := time.Now()
+
+		for {
+			select {
+			case <-stop:
+				update()
+
+				return
+			case now := <-ticker.C:
+				if now.Sub(lastSave) < chunkStateInterval {
+					sumCounters(counters, downloaded)
+
+					continue
+				}
+
+				update()
+				lastSave = now
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+func sumCounters(counters []atomic.Int64, downloaded *atomic.Int64) {
+	var total int64
+	for i := range counters {
+		total += counters[i].Load()
+	}
+
+	downloaded.Store(total)
+}
+
+func syncFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, permFile)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", path, err)
+	}
+
+	return nil
+}
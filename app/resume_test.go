@@ -3,6 +3,7 @@ package app
 import (
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 )
 
@@ -52,3 +53,24 @@ func TestFinalizePartNonexistentPath(t *testing.T) {
 		t.Error("expected error for nonexistent path")
 	}
 }
+
+func TestLoadChunkState(t *testing.T) {
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "test.zip.part"+chunkStateSuffix)
+	r := &resource{length: 30, chunks: [][2]int64{{0, 9}, {10, 19}, {20, 29}}}
+
+	if err := saveChunkState(statePath, r, []int64{10, 4, 20}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := loadChunkState(statePath, r)
+	if want := []int64{10, 4, 10}; !slices.Equal(got, want) {
+		t.Errorf("done = %v, want %v", got, want)
+	}
+
+	// another chunk layout starts over
+	other := &resource{length: 30, chunks: [][2]int64{{0, 14}, {15, 29}}}
+	if got := loadChunkState(statePath, other); !slices.Equal(got, []int64{0, 0}) {
+		t.Errorf("done for other layout = %v, want [0 0]", got)
+	}
+}
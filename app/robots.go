@@ -0,0 +1,152 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	neturl "net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	robotsAgent   = "leech"
+	robotsMaxSize = 512 * kilo
+)
+
+type robotsRule struct {
+	pattern string
+	allow   bool
+}
+
+// robotsRules are the rules of the robots.txt group that applies to
+// leech: a group naming leech, or else the * group.
+type robotsRules struct {
+	rules []robotsRule
+}
+
+// parseRobots parses robots.txt content.
+func parseRobots(content string) *robotsRules {
+	var (
+		own, wildcard []robotsRule
+		agents        []string
+		inRules       bool
+		hasOwn        bool
+	)
+
+	flush := func(rules []robotsRule) {
+		for _, agent := range agents {
+			switch {
+			case strings.Contains(agent, robotsAgent):
+				own = append(own, rules...)
+				hasOwn = true
+			case agent == "*":
+				wildcard = append(wildcard, rules...)
+			}
+		}
+	}
+
+	var group []robotsRule
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// a user-agent line after rules starts a new group
+			if inRules {
+				flush(group)
+				agents, group, inRules = nil, nil, false
+			}
+			agents = append(agents, strings.ToLower(value))
+		case "allow", "disallow":
+			inRules = true
+			if value == "" {
+				continue
+			}
+			group = append(group, robotsRule{pattern: value, allow: key == "allow"})
+		}
+	}
+
+	flush(group)
+
+	if hasOwn {
+		return &robotsRules{rules: own}
+	}
+
+	return &robotsRules{rules: wildcard}
+}
+
+// allowed reports whether path may be fetched. The longest matching rule
+// wins, allow wins a tie.
+func (rr *robotsRules) allowed(path string) bool {
+	if rr == nil {
+		return true
+	}
+
+	best, allow := -1, true
+
+	for _, rule := range rr.rules {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+
+		if n := len(rule.pattern); n > best || (n == best && rule.allow) {
+			best, allow = n, rule.allow
+		}
+	}
+
+	return allow
+}
+
+// robotsMatch matches a robots.txt path pattern supporting * and a
+// trailing $ anchor.
+func robotsMatch(pattern, path string) bool {
+	if !strings.ContainsAny(pattern, "*$") {
+		return strings.HasPrefix(path, pattern)
+	}
+
+	anchored := strings.HasSuffix(pattern, "$")
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(strings.TrimSuffix(pattern, "$")), `\*`, ".*")
+	if anchored {
+		expr += "$"
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return false
+	}
+
+	return re.MatchString(path)
+}
+
+// robotsFor returns the robots.txt rules of the host of u, fetching them
+// once per host. A missing or unreadable robots.txt allows everything.
+func (cr *crawler) robotsFor(ctx context.Context, u *neturl.URL) *robotsRules {
+	key := u.Scheme + "://" + u.Host
+	if rules, ok := cr.robots[key]; ok {
+		return rules
+	}
+
+	var rules *robotsRules
+
+	data, err := cr.app.fetchBytes(ctx, key+"/robots.txt", robotsMaxSize)
+	if err == nil {
+		rules = parseRobots(string(data))
+	} else {
+		slog.Debug("no robots.txt", "host", u.Host, logKeyError, err)
+	}
+
+	cr.robots[key] = rules
+
+	return rules
+}
@@ -0,0 +1,64 @@
+package app
+
+import "testing"
+
+func TestParseRobots(t *testing.T) {
+	content := `# comment
+User-agent: *
+Disallow: /private/
+Disallow: /*.tmp$
+
+User-agent: Googlebot
+Disallow: /
+`
+
+	rules := parseRobots(content)
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/", true},
+		{"/pub/file.iso", true},
+		{"/private/secret", false},
+		{"/pub/file.tmp", false},
+		{"/pub/file.tmp.iso", true},
+	}
+
+	for _, tt := range tests {
+		if got := rules.allowed(tt.path); got != tt.want {
+			t.Errorf("allowed(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParseRobotsOwnGroup(t *testing.T) {
+	content := `User-agent: *
+Disallow: /
+
+User-agent: wget
+User-agent: leech
+Disallow: /private/
+Allow: /private/public/
+`
+
+	rules := parseRobots(content)
+
+	if !rules.allowed("/pub/") {
+		t.Error("expected the leech group to replace the * group")
+	}
+	if rules.allowed("/private/x") {
+		t.Error("expected /private/x to be disallowed")
+	}
+	if !rules.allowed("/private/public/x") {
+		t.Error("expected the longer allow rule to win")
+	}
+}
+
+func TestRobotsRulesNil(t *testing.T) {
+	var rules *robotsRules
+
+	if !rules.allowed("/anything") {
+		t.Error("expected missing robots.txt to allow everything")
+	}
+}
@@ -0,0 +1,651 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The JSON-RPC server speaks the core of aria2's RPC interface, so web UIs
+// and browser extensions made for aria2 can drive the daemon queue. See
+// https://aria2.github.io/manual/en/html/aria2c.html#rpc-interface
+const (
+	rpcPath        = "/jsonrpc"
+	rpcMaxBody     = 1 << 20
+	rpcTokenPrefix = "token:"
+)
+
+// JSON-RPC 2.0 error codes; aria2 answers failed calls with code 1.
+const (
+	rpcCodeFailed         = 1
+	rpcCodeParse          = -32700
+	rpcCodeInvalidRequest = -32600
+	rpcCodeNoMethod       = -32601
+	rpcCodeInvalidParams  = -32602
+)
+
+// aria2Status maps the daemon statuses to aria2's.
+var aria2Status = map[string]string{
+	statusQueued:  "waiting",
+	statusActive:  "active",
+	statusPaused:  "paused",
+	statusDone:    "complete",
+	statusFailed:  "error",
+	statusRemoved: "removed",
+}
+
+type rpcError struct {
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+type rpcRequest struct {
+	JSONRPC string            `json:"jsonrpc"`
+	Method  string            `json:"method"`
+	ID      json.RawMessage   `json:"id"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+type rpcResponse struct {
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// rpcServer answers aria2 JSON-RPC calls on the daemon queue. Downloads are
+// addressed by GID, which is the queue ID as 16 hex digits.
+type rpcServer struct {
+	d      *daemon
+	secret string
+}
+
+func newRPCServer(d *daemon, secret string) *rpcServer {
+	return &rpcServer{d: d, secret: secret}
+}
+
+// startRPC serves s on addr. The returned function shuts the server down.
+func startRPC(addr string, s *rpcServer) (func(), error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open rpc listener: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(rpcPath, s)
+
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("rpc server failed", logKeyError, err)
+		}
+	}()
+
+	slog.Info("rpc listening", "address", ln.Addr().String()+rpcPath)
+
+	if s.secret == "" && !isLoopback(ln.Addr()) {
+		slog.Warn("rpc listens beyond this machine without -rpc-secret, anyone who reaches it can queue downloads",
+			"address", ln.Addr().String())
+	}
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_ = srv.Shutdown(ctx)
+	}, nil
+}
+
+func isLoopback(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+
+	return ok && tcp.IP.IsLoopback()
+}
+
+func (s *rpcServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// browser front-ends call from their own origin. Without a secret any
+	// web page could do the same, even without CORS headers: a text/plain
+	// POST is sent without a preflight. So without a secret, requests from
+	// browser pages are refused and the body must be declared JSON.
+	if s.secret != "" {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	} else if r.Header.Get("Origin") != "" {
+		http.Error(w, "browser requests need -rpc-secret", http.StatusForbidden)
+
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+
+		return
+	default:
+		w.Header().Set("Allow", "POST, OPTIONS")
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	if s.secret == "" && !isJSONContentType(r.Header.Get("Content-Type")) {
+		http.Error(w, "content type must be application/json", http.StatusUnsupportedMediaType)
+
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, rpcMaxBody))
+	if err != nil {
+		http.Error(w, "failed to read request", http.StatusBadRequest)
+
+		return
+	}
+
+	var reply any
+
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(trimmed, &batch); err != nil {
+			reply = rpcFailure(nil, rpcCodeParse, "parse error")
+		} else {
+			replies := make([]rpcResponse, len(batch))
+			for i, raw := range batch {
+				replies[i] = s.handle(raw)
+			}
+			reply = replies
+		}
+	} else {
+		reply = s.handle(body)
+	}
+
+	w.Header().Set("Content-Type", "application/json-rpc")
+	_ = json.NewEncoder(w).Encode(reply)
+}
+
+func isJSONContentType(value string) bool {
+	mediaType, _, err := mime.ParseMediaType(value)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "application/json" || mediaType == "application/json-rpc"
+}
+
+func rpcFailure(id json.RawMessage, code int, msg string) rpcResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+
+	return rpcResponse{JSONRPC: "2.0", ID: id, Error: &rpcError{Code: code, Message: msg}}
+}
+
+func (s *rpcServer) handle(raw []byte) rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return rpcFailure(nil, rpcCodeParse, "parse error")
+	}
+
+	if req.Method == "" {
+		return rpcFailure(req.ID, rpcCodeInvalidRequest, "invalid request")
+	}
+
+	result, err := s.call(req.Method, req.Params)
+	if err != nil {
+		var rerr *rpcError
+		if !errors.As(err, &rerr) {
+			rerr = &rpcError{Code: rpcCodeFailed, Message: err.Error()}
+		}
+
+		return rpcFailure(req.ID, rerr.Code, rerr.Message)
+	}
+
+	return rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: result}
+}
+
+// call runs one method. The aria2 methods take the secret as a first
+// "token:..." parameter.
+func (s *rpcServer) call(method string, params []json.RawMessage) (any, error) {
+	if method == "system.multicall" {
+		return s.multicall(params)
+	}
+
+	params, err := s.authorize(params)
+	if err != nil {
+		return nil, err
+	}
+
+	switch method {
+	case "aria2.addUri":
+		return s.addURI(params)
+	case "aria2.tellStatus":
+		return s.tellStatus(params)
+	case "aria2.tellActive":
+		return s.tell(params, 0, func(e *daemonEntry) bool { return e.Status == statusActive })
+	case "aria2.tellWaiting":
+		return s.tell(params, 2, func(e *daemonEntry) bool {
+			return e.Status == statusQueued || e.Status == statusPaused
+		})
+	case "aria2.tellStopped":
+		return s.tell(params, 2, func(e *daemonEntry) bool {
+			return e.Status == statusDone || e.Status == statusFailed
+		})
+	case "aria2.pause", "aria2.forcePause":
+		return s.change("pause", params)
+	case "aria2.unpause":
+		return s.change("resume", params)
+	case "aria2.remove", "aria2.forceRemove":
+		return s.change("remove", params)
+	case "aria2.getGlobalStat":
+		return s.globalStat(), nil
+	case "aria2.changeGlobalOption":
+		return s.changeGlobalOption(params)
+	case "aria2.getVersion":
+		return map[string]any{"version": Version, "enabledFeatures": []string{}}, nil
+	}
+
+	return nil, &rpcError{Code: rpcCodeNoMethod, Message: "method not found: " + method}
+}
+
+func (s *rpcServer) authorize(params []json.RawMessage) ([]json.RawMessage, error) {
+	var token string
+	if len(params) > 0 && json.Unmarshal(params[0], &token) == nil && strings.HasPrefix(token, rpcTokenPrefix) {
+		params = params[1:]
+	} else {
+		token = ""
+	}
+
+	if s.secret == "" {
+		return params, nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(rpcTokenPrefix+s.secret)) != 1 {
+		return nil, &rpcError{Code: rpcCodeFailed, Message: "Unauthorized"}
+	}
+
+	return params, nil
+}
+
+// multicall runs a list of {methodName, params} calls. Each result is
+// wrapped in a one element array, each failure is an error object.
+func (s *rpcServer) multicall(params []json.RawMessage) (any, error) {
+	var calls []struct {
+		MethodName string            `json:"methodName"`
+		Params     []json.RawMessage `json:"params"`
+	}
+	if err := decodeParam(params, 0, &calls, true); err != nil {
+		return nil, err
+	}
+
+	results := make([]any, len(calls))
+
+	for i, call := range calls {
+		if call.MethodName == "system.multicall" {
+			results[i] = &rpcError{Code: rpcCodeFailed, Message: "recursive system.multicall forbidden"}
+
+			continue
+		}
+
+		result, err := s.call(call.MethodName, call.Params)
+		if err != nil {
+			var rerr *rpcError
+			if !errors.As(err, &rerr) {
+				rerr = &rpcError{Code: rpcCodeFailed, Message: err.Error()}
+			}
+			results[i] = rerr
+
+			continue
+		}
+
+		results[i] = []any{result}
+	}
+
+	return results, nil
+}
+
+// decodeParam decodes params[i] into v. A missing optional parameter
+// leaves v as it is.
+func decodeParam(params []json.RawMessage, i int, v any, required bool) error {
+	if i >= len(params) {
+		if required {
+			return &rpcError{Code: rpcCodeInvalidParams, Message: fmt.Sprintf("missing parameter %d", i+1)}
+		}
+
+		return nil
+	}
+
+	if err := json.Unmarshal(params[i], v); err != nil {
+		return &rpcError{Code: rpcCodeInvalidParams, Message: fmt.Sprintf("invalid parameter %d: %v", i+1, err)}
+	}
+
+	return nil
+}
+
+func formatGID(id int) string {
+	return fmt.Sprintf("%016x", id)
+}
+
+// entry returns the entry of the GID in params[0]. Callers hold mu.
+func (s *rpcServer) entry(params []json.RawMessage) (*daemonEntry, error) {
+	var gid string
+	if err := decodeParam(params, 0, &gid, true); err != nil {
+		return nil, err
+	}
+
+	id, err := strconv.ParseInt(gid, 16, 64)
+	if err != nil || len(gid) > 16 {
+		return nil, fmt.Errorf("GID %s is not found", gid)
+	}
+
+	e, err := s.d.find(strconv.FormatInt(id, 10))
+	if err != nil {
+		return nil, fmt.Errorf("GID %s is not found", gid)
+	}
+
+	return e, nil
+}
+
+// addURI queues the first of the given mirror URIs. Of the options only
+// out, the file name, is used; files go to the -output of the daemon.
+func (s *rpcServer) addURI(params []json.RawMessage) (any, error) {
+	var (
+		uris     []string
+		options  map[string]any
+		position = -1
+	)
+
+	if err := decodeParam(params, 0, &uris, true); err != nil {
+		return nil, err
+	}
+	if err := decodeParam(params, 1, &options, false); err != nil {
+		return nil, err
+	}
+	if err := decodeParam(params, 2, &position, false); err != nil {
+		return nil, err
+	}
+
+	if len(uris) == 0 {
+		return nil, &rpcError{Code: rpcCodeInvalidParams, Message: "no uri given"}
+	}
+
+	url, err := parseValidateURL(uris[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var out string
+	if v, ok := options["out"]; ok {
+		out = filepath.Base(filepath.Clean(fmt.Sprint(v)))
+		if out == "." || out == ".." || out == string(filepath.Separator) {
+			return nil, &rpcError{Code: rpcCodeInvalidParams, Message: fmt.Sprintf("invalid out %q", v)}
+		}
+	}
+
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+
+	e := s.d.enqueue(url)
+	if out != "" {
+		// numbered like any other name taken by a file or queued download
+		r := &resource{filename: out}
+		s.d.names.claim(r)
+		e.Filename = r.filename
+	}
+
+	if position >= 0 {
+		s.d.entries = moveWaiting(s.d.entries, e, position)
+	}
+
+	s.d.save()
+	s.d.notify()
+
+	return formatGID(e.ID), nil
+}
+
+// moveWaiting moves e, the last entry, in front of the waiting entry at
+// position, so it starts before that one.
+func moveWaiting(entries []*daemonEntry, e *daemonEntry, position int) []*daemonEntry {
+	entries = removeEntry(entries, e)
+
+	waiting := 0
+	for i, other := range entries {
+		if other.Status != statusQueued && other.Status != statusPaused {
+			continue
+		}
+
+		if waiting == position {
+			return append(entries[:i], append([]*daemonEntry{e}, entries[i:]...)...)
+		}
+
+		waiting++
+	}
+
+	return append(entries, e)
+}
+
+func (s *rpcServer) tellStatus(params []json.RawMessage) (any, error) {
+	var keys []string
+	if err := decodeParam(params, 1, &keys, false); err != nil {
+		return nil, err
+	}
+
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+
+	e, err := s.entry(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.status(e, keys), nil
+}
+
+// tell lists the statuses of the entries matching keep. The waiting and
+// stopped lists take an offset and a count before the keys, at keysAt.
+func (s *rpcServer) tell(params []json.RawMessage, keysAt int, keep func(*daemonEntry) bool) (any, error) {
+	var (
+		offset int
+		num    = -1
+		keys   []string
+	)
+
+	if keysAt > 0 {
+		if err := decodeParam(params, 0, &offset, true); err != nil {
+			return nil, err
+		}
+		if err := decodeParam(params, 1, &num, true); err != nil {
+			return nil, err
+		}
+	}
+	if err := decodeParam(params, keysAt, &keys, false); err != nil {
+		return nil, err
+	}
+
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+
+	var matched []*daemonEntry
+	for _, e := range s.d.entries {
+		if keep(e) {
+			matched = append(matched, e)
+		}
+	}
+
+	// a negative offset counts from the end, listing backwards
+	if offset < 0 {
+		slices.Reverse(matched)
+		offset = -offset - 1
+	}
+
+	statuses := []map[string]any{}
+	for i := offset; i < len(matched) && (num < 0 || len(statuses) < num); i++ {
+		statuses = append(statuses, s.status(matched[i], keys))
+	}
+
+	return statuses, nil
+}
+
+// status is the aria2 status struct of e, limited to keys if any are
+// given. Numbers are strings, as in aria2. Callers hold mu.
+func (s *rpcServer) status(e *daemonEntry, keys []string) map[string]any {
+	dir, err := filepath.Abs(filepath.Join(s.d.app.outputDir, filepath.FromSlash(e.Dir)))
+	if err != nil {
+		dir = filepath.Join(s.d.app.outputDir, filepath.FromSlash(e.Dir))
+	}
+
+	completed := e.downloaded.Load()
+	if e.Status == statusDone {
+		completed = max(completed, e.Length)
+	}
+
+	path := ""
+	if e.Filename != "" {
+		path = filepath.Join(dir, e.Filename)
+	}
+
+	connections := "0"
+	if e.Status == statusActive {
+		connections = "1"
+	}
+
+	errorCode := "0"
+	if e.Status == statusFailed {
+		errorCode = "1"
+	}
+
+	length := strconv.FormatInt(e.Length, 10)
+	done := strconv.FormatInt(completed, 10)
+
+	st := map[string]any{
+		"gid":             formatGID(e.ID),
+		"status":          aria2Status[e.Status],
+		"totalLength":     length,
+		"completedLength": done,
+		"uploadLength":    "0",
+		"downloadSpeed":   strconv.FormatInt(e.speed.Load(), 10),
+		"uploadSpeed":     "0",
+		"connections":     connections,
+		"numPieces":       "1",
+		"pieceLength":     length,
+		"dir":             dir,
+		"errorCode":       errorCode,
+		"errorMessage":    e.Error,
+		"files": []map[string]any{{
+			"index":           "1",
+			"path":            path,
+			"length":          length,
+			"completedLength": done,
+			"selected":        "true",
+			"uris":            []map[string]string{{"uri": e.URL, "status": "used"}},
+		}},
+	}
+
+	if len(keys) == 0 {
+		return st
+	}
+
+	picked := make(map[string]any, len(keys))
+	for _, k := range keys {
+		if v, ok := st[k]; ok {
+			picked[k] = v
+		}
+	}
+
+	return picked
+}
+
+func (s *rpcServer) change(name string, params []json.RawMessage) (any, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+
+	e, err := s.entry(params)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.d.changeEntry(name, e); err != nil {
+		return nil, err
+	}
+
+	return formatGID(e.ID), nil
+}
+
+func (s *rpcServer) globalStat() map[string]string {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+
+	var speed int64
+	counts := make(map[string]int)
+
+	for _, e := range s.d.entries {
+		speed += e.speed.Load()
+		counts[aria2Status[e.Status]]++
+	}
+
+	stopped := strconv.Itoa(counts["complete"] + counts["error"])
+
+	return map[string]string{
+		"downloadSpeed":   strconv.FormatInt(speed, 10),
+		"uploadSpeed":     "0",
+		"numActive":       strconv.Itoa(counts["active"]),
+		"numWaiting":      strconv.Itoa(counts["waiting"] + counts["paused"]),
+		"numStopped":      stopped,
+		"numStoppedTotal": stopped,
+	}
+}
+
+// changeGlobalOption supports the overall limit and the number of
+// downloads at once; other options are ignored, front-ends send many.
+func (s *rpcServer) changeGlobalOption(params []json.RawMessage) (any, error) {
+	var options map[string]any
+	if err := decodeParam(params, 0, &options, true); err != nil {
+		return nil, err
+	}
+
+	for name, v := range options {
+		value := fmt.Sprint(v)
+
+		switch name {
+		case "max-overall-download-limit":
+			rate, err := parseRate(value)
+			if err != nil {
+				return nil, err
+			}
+
+			s.d.app.changeLimit(rate, "rpc")
+		case "max-concurrent-downloads":
+			jobs, err := strconv.Atoi(value)
+			if err != nil || jobs < 1 {
+				return nil, fmt.Errorf("invalid max-concurrent-downloads %q", value)
+			}
+
+			s.d.mu.Lock()
+			s.d.app.jobs = jobs
+			s.d.mu.Unlock()
+			s.d.notify()
+		default:
+			slog.Debug("ignoring rpc option", "option", name)
+		}
+	}
+
+	return "OK", nil
+}
@@ -0,0 +1,251 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type rpcTestReply struct {
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+func rpcCall(t *testing.T, url, method string, params ...any) rpcTestReply {
+	t.Helper()
+
+	body, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": "1", "method": method, "params": params})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(url+rpcPath, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var reply rpcTestReply
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+
+	return reply
+}
+
+func rpcResult[T any](t *testing.T, reply rpcTestReply) T {
+	t.Helper()
+
+	var v T
+	if reply.Error != nil {
+		t.Fatalf("rpc error: %d %s", reply.Error.Code, reply.Error.Message)
+	}
+	if err := json.Unmarshal(reply.Result, &v); err != nil {
+		t.Fatal(err)
+	}
+
+	return v
+}
+
+func newTestRPC(t *testing.T, secret string) (*daemon, *httptest.Server) {
+	t.Helper()
+
+	d := newTestDaemon(t, &CLIApplication{})
+
+	mux := http.NewServeMux()
+	mux.Handle(rpcPath, newRPCServer(d, secret))
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	return d, ts
+}
+
+func TestRPCQueue(t *testing.T) {
+	d, ts := newTestRPC(t, "s3cret")
+	token := "token:s3cret"
+
+	gid := rpcResult[string](t, rpcCall(t, ts.URL, "aria2.addUri", token,
+		[]string{"http://example.com/a.iso"}, map[string]string{"out": "renamed.iso"}))
+	if gid != "0000000000000001" {
+		t.Errorf("gid = %q, want 0000000000000001", gid)
+	}
+	if d.entries[0].Filename != "renamed.iso" {
+		t.Errorf("filename = %q, want renamed.iso", d.entries[0].Filename)
+	}
+
+	// a position puts it in front of the waiting downloads
+	second := rpcResult[string](t, rpcCall(t, ts.URL, "aria2.addUri", token,
+		[]string{"http://example.com/b.iso"}, map[string]string{}, 0))
+	if d.entries[0].URL != "http://example.com/b.iso" {
+		t.Errorf("first entry = %q, want the one added at position 0", d.entries[0].URL)
+	}
+
+	st := rpcResult[map[string]any](t, rpcCall(t, ts.URL, "aria2.tellStatus", token, gid, []string{"gid", "status"}))
+	if st["status"] != "waiting" || st["gid"] != gid || len(st) != 2 {
+		t.Errorf("tellStatus = %v", st)
+	}
+
+	rpcResult[string](t, rpcCall(t, ts.URL, "aria2.pause", token, gid))
+	st = rpcResult[map[string]any](t, rpcCall(t, ts.URL, "aria2.tellStatus", token, gid))
+	if st["status"] != "paused" {
+		t.Errorf("status after pause = %v", st["status"])
+	}
+
+	waiting := rpcResult[[]map[string]any](t, rpcCall(t, ts.URL, "aria2.tellWaiting", token, 0, 10, []string{"gid"}))
+	if len(waiting) != 2 {
+		t.Errorf("tellWaiting = %v, want 2 entries", waiting)
+	}
+
+	stat := rpcResult[map[string]string](t, rpcCall(t, ts.URL, "aria2.getGlobalStat", token))
+	if stat["numWaiting"] != "2" || stat["numActive"] != "0" {
+		t.Errorf("getGlobalStat = %v", stat)
+	}
+
+	rpcResult[string](t, rpcCall(t, ts.URL, "aria2.unpause", token, gid))
+	rpcResult[string](t, rpcCall(t, ts.URL, "aria2.remove", token, second))
+
+	if reply := rpcCall(t, ts.URL, "aria2.tellStatus", token, second); reply.Error == nil {
+		t.Error("tellStatus of a removed download should fail")
+	}
+	if len(d.entries) != 1 || d.entries[0].Status != statusQueued {
+		t.Errorf("entries = %+v", d.entries)
+	}
+}
+
+func TestRPCSecret(t *testing.T) {
+	_, ts := newTestRPC(t, "s3cret")
+
+	for _, params := range [][]any{{}, {"token:wrong"}} {
+		reply := rpcCall(t, ts.URL, "aria2.getGlobalStat", params...)
+		if reply.Error == nil || reply.Error.Message != "Unauthorized" {
+			t.Errorf("getGlobalStat%v error = %v, want Unauthorized", params, reply.Error)
+		}
+	}
+}
+
+func TestRPCChangeGlobalOption(t *testing.T) {
+	d, ts := newTestRPC(t, "")
+
+	ok := rpcResult[string](t, rpcCall(t, ts.URL, "aria2.changeGlobalOption",
+		map[string]string{"max-overall-download-limit": "1M", "max-concurrent-downloads": "3", "dir": "/tmp"}))
+	if ok != "OK" {
+		t.Errorf("changeGlobalOption = %q, want OK", ok)
+	}
+
+	if got := d.app.limiter.limit(); got != mega {
+		t.Errorf("limit = %d, want %d", got, mega)
+	}
+	if d.app.jobs != 3 {
+		t.Errorf("jobs = %d, want 3", d.app.jobs)
+	}
+
+	reply := rpcCall(t, ts.URL, "aria2.changeGlobalOption", map[string]string{"max-concurrent-downloads": "none"})
+	if reply.Error == nil {
+		t.Error("an invalid option value should fail")
+	}
+}
+
+func TestRPCMulticall(t *testing.T) {
+	_, ts := newTestRPC(t, "")
+
+	results := rpcResult[[]json.RawMessage](t, rpcCall(t, ts.URL, "system.multicall", []map[string]any{
+		{"methodName": "aria2.getVersion", "params": []any{}},
+		{"methodName": "aria2.nope", "params": []any{}},
+	}))
+	if len(results) != 2 {
+		t.Fatalf("results = %s, want 2", results)
+	}
+
+	var version []map[string]any
+	if err := json.Unmarshal(results[0], &version); err != nil || len(version) != 1 || version[0]["version"] != Version {
+		t.Errorf("getVersion result = %s", results[0])
+	}
+
+	var failure rpcError
+	if err := json.Unmarshal(results[1], &failure); err != nil || failure.Code != rpcCodeNoMethod {
+		t.Errorf("unknown method result = %s", results[1])
+	}
+}
+
+func TestRPCRejectsGet(t *testing.T) {
+	_, ts := newTestRPC(t, "")
+
+	resp, err := http.Get(ts.URL + rpcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestRPCRefusesBrowserRequestsWithoutSecret(t *testing.T) {
+	body := `{"jsonrpc":"2.0","id":1,"method":"aria2.addUri","params":[["http://example.com/a.iso"]]}`
+
+	tests := []struct {
+		name        string
+		secret      string
+		origin      string
+		contentType string
+		want        int
+	}{
+		{"json", "", "", "application/json", http.StatusOK},
+		{"json with charset", "", "", "application/json; charset=utf-8", http.StatusOK},
+		{"text/plain, no preflight", "", "", "text/plain", http.StatusUnsupportedMediaType},
+		{"cross origin", "", "http://evil.example", "application/json", http.StatusForbidden},
+		{"cross origin with secret", "s3cret", "http://ui.example", "text/plain", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, ts := newTestRPC(t, tt.secret)
+
+			req, err := http.NewRequest(http.MethodPost, ts.URL+rpcPath, strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", tt.contentType)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+			if tt.want != http.StatusOK && len(d.entries) != 0 {
+				t.Error("a refused request must not queue anything")
+			}
+		})
+	}
+}
+
+func TestRPCAddURIOutIsDeduplicated(t *testing.T) {
+	d, ts := newTestRPC(t, "")
+
+	existing := filepath.Join(d.app.outputDir, "keep.txt")
+	if err := os.WriteFile(existing, []byte("keep me"), permFile); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"keep_1.txt", "keep_2.txt"} {
+		rpcResult[string](t, rpcCall(t, ts.URL, "aria2.addUri",
+			[]string{"http://example.com/keep.txt"}, map[string]string{"out": "keep.txt"}))
+
+		if got := d.entries[len(d.entries)-1].Filename; got != want {
+			t.Errorf("filename = %q, want %q", got, want)
+		}
+	}
+}
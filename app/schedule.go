@@ -0,0 +1,202 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+var errInvalidSchedule = errors.New("invalid limit schedule")
+
+const minutesPerDay = 24 * 60
+
+// scheduleWindow applies rate from start up to end, in minutes since
+// midnight. A window with end <= start wraps past midnight.
+type scheduleWindow struct {
+	start int
+	end   int
+	rate  int64
+}
+
+// limitSchedule switches the global rate by local time of day. Times
+// outside every window use fallback, the -limit value.
+type limitSchedule struct {
+	windows  []scheduleWindow
+	fallback int64
+}
+
+// parseSchedule parses "08:00-18:00=1M,18:00-08:00=0".
+func parseSchedule(s string, fallback int64) (*limitSchedule, error) {
+	ls := &limitSchedule{fallback: fallback}
+
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		span, rateStr, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q, want HH:MM-HH:MM=RATE", errInvalidSchedule, entry)
+		}
+
+		from, to, ok := strings.Cut(span, "-")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q, want HH:MM-HH:MM=RATE", errInvalidSchedule, entry)
+		}
+
+		start, err := parseClock(from)
+		if err != nil {
+			return nil, err
+		}
+
+		end, err := parseClock(to)
+		if err != nil {
+			return nil, err
+		}
+
+		rate, err := parseRate(rateStr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %w", errInvalidSchedule, entry, err)
+		}
+
+		ls.windows = append(ls.windows, scheduleWindow{start: start, end: end, rate: rate})
+	}
+
+	if len(ls.windows) == 0 {
+		return nil, fmt.Errorf("%w: no entries", errInvalidSchedule)
+	}
+
+	return ls, nil
+}
+
+// parseClock returns the minutes since midnight of an HH:MM time.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("%w: bad time %q", errInvalidSchedule, s)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w scheduleWindow) contains(minute int) bool {
+	if w.start < w.end {
+		return minute >= w.start && minute < w.end
+	}
+
+	// wraps past midnight; start == end covers the whole day
+	return minute >= w.start || minute < w.end
+}
+
+// rateAt returns the rate for t. The first matching window wins.
+func (ls *limitSchedule) rateAt(t time.Time) int64 {
+	minute := t.Hour()*60 + t.Minute()
+
+	for _, w := range ls.windows {
+		if w.contains(minute) {
+			return w.rate
+		}
+	}
+
+	return ls.fallback
+}
+
+// nextChange returns the next window boundary after t. The boundary is
+// a wall clock time, so on a daylight saving day it is not a fixed number
+// of minutes after midnight.
+func (ls *limitSchedule) nextChange(t time.Time) time.Time {
+	minute := t.Hour()*60 + t.Minute()
+
+	next := minutesPerDay + 1
+
+	for _, w := range ls.windows {
+		for _, boundary := range []int{w.start, w.end} {
+			delta := (boundary - minute + minutesPerDay) % minutesPerDay
+			if delta == 0 {
+				delta = minutesPerDay
+			}
+			next = min(next, delta)
+		}
+	}
+
+	// time.Date carries minutes past the end of the day into the next one
+	at := time.Date(t.Year(), t.Month(), t.Day(), 0, minute+next, 0, 0, t.Location())
+
+	// a wall clock time repeated when the clocks go back may name the
+	// earlier of the two instants
+	for !at.After(t) {
+		at = at.Add(time.Hour)
+	}
+
+	return at
+}
+
+// nextRate returns when the rate next differs from the one at t and what it
+// becomes. ok is false if the schedule keeps the rate all day.
+func (ls *limitSchedule) nextRate(t time.Time) (at time.Time, rate int64, ok bool) {
+	current := ls.rateAt(t)
+	at = t
+
+	// every window has two boundaries, one of them changes the rate if any does
+	for range 2 * len(ls.windows) {
+		at = ls.nextChange(at)
+		if rate = ls.rateAt(at); rate != current {
+			return at, rate, true
+		}
+	}
+
+	return time.Time{}, 0, false
+}
+
+// scheduleStatus is the part of the limit status line with the next
+// scheduled change, e.g. "1.0MB/s at 08:00", or "" without a schedule.
+func (c *CLIApplication) scheduleStatus() string {
+	if c.schedule == nil {
+		return ""
+	}
+
+	at, rate, ok := c.schedule.nextRate(time.Now())
+	if !ok {
+		return ""
+	}
+
+	return formatLimit(rate) + " at " + at.Format("15:04")
+}
+
+// runSchedule applies the scheduled rate to the global limiter now and at
+// every boundary until ctx is done.
+func (c *CLIApplication) runSchedule(ctx context.Context) {
+	current := c.limiter.limit()
+
+	for {
+		now := time.Now()
+
+		if rate := c.schedule.rateAt(now); rate != current {
+			slog.Info("bandwidth limit changed", "from", formatLimit(current), "to", formatLimit(rate))
+			c.limiter.setRate(rate)
+			current = rate
+		}
+
+		timer := time.NewTimer(time.Until(c.schedule.nextChange(now)))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func formatLimit(rate int64) string {
+	if rate == 0 {
+		return "unlimited"
+	}
+
+	return formatBytes(rate) + "/s"
+}
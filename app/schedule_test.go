@@ -0,0 +1,127 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func at(hour, minute int) time.Time {
+	return time.Date(2024, 5, 6, hour, minute, 30, 0, time.Local)
+}
+
+func TestParseSchedule(t *testing.T) {
+	ls, err := parseSchedule("08:00-18:00=1M, 18:00-08:00=0", 5*kilo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		t    time.Time
+		want int64
+	}{
+		{at(7, 59), 0},
+		{at(8, 0), mega},
+		{at(17, 59), mega},
+		{at(18, 0), 0},
+		{at(0, 0), 0},
+	}
+
+	for _, tt := range tests {
+		if got := ls.rateAt(tt.t); got != tt.want {
+			t.Errorf("rateAt(%s) = %d, want %d", tt.t.Format("15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestScheduleFallback(t *testing.T) {
+	ls, err := parseSchedule("12:00-13:00=100K", 5*kilo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := ls.rateAt(at(9, 0)); got != 5*kilo {
+		t.Errorf("rateAt outside every window = %d, want the -limit value", got)
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, s := range []string{"", "08:00-18:00", "08:00=1M", "25:00-26:00=1M", "08:00-18:00=fast"} {
+		if _, err := parseSchedule(s, 0); !errors.Is(err, errInvalidSchedule) {
+			t.Errorf("parseSchedule(%q) error = %v, want %v", s, err, errInvalidSchedule)
+		}
+	}
+}
+
+func TestScheduleNextChange(t *testing.T) {
+	ls, _ := parseSchedule("08:00-18:00=1M,18:00-08:00=0", 0)
+
+	if got, want := ls.nextChange(at(9, 15)), time.Date(2024, 5, 6, 18, 0, 0, 0, time.Local); !got.Equal(want) {
+		t.Errorf("nextChange = %v, want %v", got, want)
+	}
+
+	if got, want := ls.nextChange(at(18, 0)), time.Date(2024, 5, 7, 8, 0, 0, 0, time.Local); !got.Equal(want) {
+		t.Errorf("nextChange = %v, want %v", got, want)
+	}
+}
+
+func TestScheduleNextChangeDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	ls, _ := parseSchedule("08:00-18:00=1M", 0)
+
+	// the clocks go forward on March 10 and back on November 3
+	for _, day := range []time.Time{
+		time.Date(2024, time.March, 10, 1, 0, 0, 0, loc),
+		time.Date(2024, time.November, 3, 1, 0, 0, 0, loc),
+	} {
+		want := time.Date(day.Year(), day.Month(), day.Day(), 8, 0, 0, 0, loc)
+		if got := ls.nextChange(day); !got.Equal(want) {
+			t.Errorf("nextChange(%v) = %v, want %v", day, got, want)
+		}
+	}
+}
+
+func TestScheduleNextRate(t *testing.T) {
+	// the 18:00 boundary keeps 1M, the rate changes at 20:00
+	ls, _ := parseSchedule("08:00-18:00=1M,18:00-20:00=1M", 0)
+
+	got, rate, ok := ls.nextRate(at(9, 15))
+	if want := time.Date(2024, 5, 6, 20, 0, 0, 0, time.Local); !ok || !got.Equal(want) || rate != 0 {
+		t.Errorf("nextRate = %v, %d, %v, want %v, 0, true", got, rate, ok, want)
+	}
+
+	whole, _ := parseSchedule("00:00-00:00=7K", 0)
+	if _, _, ok := whole.nextRate(at(9, 15)); ok {
+		t.Error("a schedule that never changes the rate has no next rate")
+	}
+}
+
+func TestRunScheduleAppliesRate(t *testing.T) {
+	ls, _ := parseSchedule("00:00-00:00=7K", 0)
+	app := &CLIApplication{limiter: newRateLimiter(0), schedule: ls}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		app.runSchedule(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for app.limiter.limit() != 7*kilo && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if got := app.limiter.limit(); got != 7*kilo {
+		t.Errorf("limit = %d, want %d", got, 7*kilo)
+	}
+
+	cancel()
+	<-done
+}
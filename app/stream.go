@@ -0,0 +1,196 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync/atomic"
+)
+
+var errShortPiece = errors.New("short read")
+
+const (
+	stdoutName          = "-"
+	defaultStreamBuffer = 64 * mega
+	streamMinPiece      = 64 * kilo
+	streamMaxPiece      = 8 * mega
+)
+
+// orderedPart is a fetched piece waiting for its turn to be written.
+type orderedPart struct {
+	data []byte
+	err  error
+}
+
+// streamPieces splits length bytes starting at start into pieces small
+// enough that every worker can have two pieces in flight within buffer.
+func streamPieces(start, length, buffer int64, workers int) [][2]int64 {
+	size := buffer / int64(2*max(workers, 1))
+	size = min(max(size, streamMinPiece), streamMaxPiece)
+
+	var pieces [][2]int64
+	for off := int64(0); off < length; off += size {
+		pieces = append(pieces, [2]int64{start + off, start + min(off+size, length) - 1})
+	}
+
+	return pieces
+}
+
+// runStdout writes every resource to c.Out, one after another, like cat.
+// Progress goes to stderr as usual, so the output can be piped.
+func (c *CLIApplication) runStdout(ctx context.Context, resources <-chan *resource) error {
+	pd := newProgressDisplay()
+	pd.status = c.limitStatus
+
+	pd.start()
+	defer pd.finish()
+
+	for r := range resources {
+		c.dropHint(r.url)
+
+		var downloaded atomic.Int64
+		pd.add(r.filename, &downloaded, r.length)
+
+		if err := c.writeOrdered(ctx, r, c.Out, &downloaded); err != nil {
+			return fmt.Errorf("%s: %w", r.url, err)
+		}
+
+		slog.Info("download complete", logKeyURL, r.url, "size", formatBytes(downloaded.Load()))
+	}
+
+	return nil
+}
+
+// writeOrdered downloads r to w strictly in order. Ranged resources are
+// fetched in parallel pieces and reassembled in a reorder buffer that
+// holds at most streamBuf bytes.
+func (c *CLIApplication) writeOrdered(ctx context.Context, r *resource, w io.Writer, downloaded *atomic.Int64) error {
+	switch {
+	case r.hls != nil:
+		return c.writeHLS(ctx, r, w, downloaded, 0, nil)
+	case r.chunks == nil:
+		return c.writeSequential(ctx, r, w, downloaded)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	buffer := c.streamBuf
+	if buffer <= 0 {
+		buffer = defaultStreamBuffer
+	}
+
+	workers := max(c.chunkSize, 1)
+	pieces := streamPieces(r.offset, r.length, buffer, workers)
+
+	pieceSize := pieces[0][1] - pieces[0][0] + 1
+	inFlight := int(max(buffer/pieceSize, 1))
+
+	// slots bounds the pieces held in memory, fetching bounds the open
+	// connections; slots are taken in piece order, so the piece the writer
+	// waits for always has one
+	slots := make(chan struct{}, inFlight)
+	fetching := make(chan struct{}, min(workers, inFlight))
+
+	results := make([]chan orderedPart, len(pieces))
+	for i := range results {
+		results[i] = make(chan orderedPart, 1)
+	}
+
+	go func() {
+		for i, piece := range pieces {
+			if !acquireAll(ctx, slots, fetching) {
+				results[i] <- orderedPart{err: ctx.Err()}
+
+				continue
+			}
+
+			go func() {
+				data, err := c.fetchPiece(ctx, r, piece, downloaded)
+				<-fetching
+				results[i] <- orderedPart{data: data, err: err}
+			}()
+		}
+	}()
+
+	for i := range pieces {
+		part := <-results[i]
+		if part.err != nil {
+			return fmt.Errorf("piece %d failed: %w", i, part.err)
+		}
+
+		if _, err := w.Write(part.data); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+
+		<-slots
+	}
+
+	return nil
+}
+
+// acquireAll takes a token from each semaphore in order. It returns false
+// if ctx is done first.
+func acquireAll(ctx context.Context, sems ...chan struct{}) bool {
+	for _, sem := range sems {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c *CLIApplication) fetchPiece(
+	ctx context.Context, r *resource, piece [2]int64, downloaded *atomic.Int64,
+) ([]byte, error) {
+	backend, err := c.fetcherFor(r.url)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := backend.openRange(ctx, r.url, piece[0], piece[1])
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = body.Close() }()
+
+	reader := c.throttle(ctx, body, r.url, r.limiter)
+	reader = &countingReader{reader: reader, counter: downloaded}
+
+	data := make([]byte, piece[1]-piece[0]+1)
+	if n, err := io.ReadFull(reader, data); err != nil {
+		return nil, fmt.Errorf("%w: got %d bytes, want %d: %w", errShortPiece, n, len(data), err)
+	}
+
+	return data, nil
+}
+
+// writeSequential copies a resource without range support to w.
+func (c *CLIApplication) writeSequential(
+	ctx context.Context, r *resource, w io.Writer, downloaded *atomic.Int64,
+) error {
+	backend, err := c.fetcherFor(r.url)
+	if err != nil {
+		return err
+	}
+
+	body, _, err := backend.openStream(ctx, r.url, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = body.Close() }()
+
+	reader := c.throttle(ctx, body, r.url, r.limiter)
+	reader = &countingReader{reader: reader, counter: downloaded}
+
+	if _, err := io.Copy(w, reader); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+
+	return nil
+}
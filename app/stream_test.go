@@ -0,0 +1,146 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStreamPieces(t *testing.T) {
+	pieces := streamPieces(100, 300*kilo, 256*kilo, 2)
+
+	if len(pieces) != 5 {
+		t.Fatalf("got %d pieces, want 5", len(pieces))
+	}
+
+	if pieces[0] != [2]int64{100, 100 + 64*kilo - 1} {
+		t.Errorf("first piece = %v", pieces[0])
+	}
+
+	if last := pieces[len(pieces)-1]; last[1] != 100+300*kilo-1 {
+		t.Errorf("last piece ends at %d", last[1])
+	}
+
+	if size := streamPieces(0, giga, giga, 1)[0]; size[1]+1 != streamMaxPiece {
+		t.Errorf("piece size = %d, want %d", size[1]+1, streamMaxPiece)
+	}
+}
+
+func TestWriteOrdered(t *testing.T) {
+	content := make([]byte, 6*streamMinPiece+123)
+	for i := range content {
+		content[i] = byte(rand.IntN(256))
+	}
+
+	var active, peak atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Add(1)
+		defer active.Add(-1)
+
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+
+		// early pieces finish last, so the reorder buffer is needed
+		if strings.HasPrefix(r.Header.Get("Range"), "bytes=0-") {
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		w.Header().Set("Accept-Ranges", "bytes")
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	app := &CLIApplication{Client: ts.Client(), chunkSize: 4, streamBuf: 2 * streamMinPiece}
+
+	r, err := app.getResourceInformation(context.Background(), ts.URL+"/file.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		out        bytes.Buffer
+		downloaded atomic.Int64
+	)
+
+	peak.Store(0)
+
+	if err := app.writeOrdered(context.Background(), r, &out, &downloaded); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(out.Bytes(), content) {
+		t.Error("output differs from the remote file")
+	}
+
+	if p := peak.Load(); p > 2 {
+		t.Errorf("peak concurrent pieces = %d, want at most 2 within the buffer", p)
+	}
+
+	if downloaded.Load() != int64(len(content)) {
+		t.Errorf("downloaded = %d, want %d", downloaded.Load(), len(content))
+	}
+}
+
+func TestWriteOrderedWithoutRanges(t *testing.T) {
+	content := []byte("streamed without range support")
+	ts := newTestServer(content, false)
+	defer ts.Close()
+
+	app := &CLIApplication{Client: ts.Client(), chunkSize: 4}
+
+	r, err := app.getResourceInformation(context.Background(), ts.URL+"/file.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		out        bytes.Buffer
+		downloaded atomic.Int64
+	)
+
+	if err := app.writeOrdered(context.Background(), r, &out, &downloaded); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.String() != string(content) {
+		t.Errorf("output = %q, want %q", out.String(), content)
+	}
+}
+
+func TestRunStdoutConcatenates(t *testing.T) {
+	ts := newTestServer([]byte("0123456789"), true)
+	defer ts.Close()
+
+	var out bytes.Buffer
+
+	app := &CLIApplication{Client: ts.Client(), Out: &out, chunkSize: 3}
+
+	resources := make(chan *resource, 2)
+	for _, path := range []string{"/a", "/b"} {
+		r, err := app.getResourceInformation(context.Background(), ts.URL+path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resources <- r
+	}
+	close(resources)
+
+	if err := app.runStdout(context.Background(), resources); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.String() != "01234567890123456789" {
+		t.Errorf("output = %q", out.String())
+	}
+}
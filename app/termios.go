@@ -0,0 +1,41 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package app
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// enableCbreak switches a terminal to reading single key presses without
+// echo. Signals such as Ctrl-C keep working. The returned function
+// restores the previous mode.
+func enableCbreak(f *os.File) (func(), error) {
+	var old syscall.Termios
+
+	if err := termiosIoctl(f, ioctlGetTermios, &old); err != nil {
+		return nil, fmt.Errorf("not a terminal: %w", err)
+	}
+
+	raw := old
+	raw.Lflag &^= syscall.ICANON | syscall.ECHO
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+
+	if err := termiosIoctl(f, ioctlSetTermios, &raw); err != nil {
+		return nil, fmt.Errorf("failed to set terminal mode: %w", err)
+	}
+
+	return func() { _ = termiosIoctl(f, ioctlSetTermios, &old) }, nil
+}
+
+func termiosIoctl(f *os.File, request uintptr, t *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), request, uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package app
+
+import (
+	"errors"
+	"os"
+)
+
+// enableCbreak is not supported here, keyboard control stays off.
+func enableCbreak(*os.File) (func(), error) {
+	return nil, errors.New("keyboard control is not supported on this platform")
+}
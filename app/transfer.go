@@ -0,0 +1,150 @@
+package app
+
+import (
+	"context"
+	"sync"
+)
+
+// transfer states, as set from the keyboard
+const (
+	transferRunning = iota
+	transferPaused
+	transferCanceled
+	transferDone // completed or failed, the keys no longer apply
+)
+
+// transferControl pauses, resumes and cancels one download from the
+// keyboard. A pause stops the running attempt and keeps the part file, a
+// resume starts a new attempt that continues from it.
+type transferControl struct {
+	cancel  context.CancelFunc // stops the running attempt
+	resumed chan struct{}      // closed when a paused transfer resumes
+	state   int
+	mu      sync.Mutex
+}
+
+func newTransferControl() *transferControl {
+	return &transferControl{}
+}
+
+func (t *transferControl) current() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.state
+}
+
+func (t *transferControl) pause() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.state != transferRunning {
+		return
+	}
+
+	t.state = transferPaused
+	t.resumed = make(chan struct{})
+
+	if t.cancel != nil {
+		t.cancel()
+	}
+}
+
+func (t *transferControl) resume() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.state != transferPaused {
+		return
+	}
+
+	t.state = transferRunning
+	close(t.resumed)
+}
+
+// togglePause pauses a running transfer and resumes a paused one.
+func (t *transferControl) togglePause() {
+	if t.current() == transferPaused {
+		t.resume()
+	} else {
+		t.pause()
+	}
+}
+
+func (t *transferControl) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.state == transferCanceled || t.state == transferDone {
+		return
+	}
+
+	if t.state == transferPaused {
+		close(t.resumed)
+	}
+
+	t.state = transferCanceled
+
+	if t.cancel != nil {
+		t.cancel()
+	}
+}
+
+// run calls fetch until it completes, fails or is canceled. An attempt
+// stopped by a pause is started again once the transfer is resumed.
+func (t *transferControl) run(ctx context.Context, fetch func(context.Context) bool) (ok bool) {
+	defer func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		if ok || t.state != transferCanceled {
+			if t.state == transferPaused {
+				close(t.resumed)
+			}
+			t.state = transferDone
+		}
+	}()
+
+	for {
+		t.mu.Lock()
+		state, resumed := t.state, t.resumed
+		t.mu.Unlock()
+
+		switch state {
+		case transferCanceled:
+			return false
+		case transferPaused:
+			select {
+			case <-resumed:
+				continue
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		attemptCtx, cancel := context.WithCancel(ctx)
+
+		t.mu.Lock()
+		if t.state != transferRunning {
+			// paused or canceled before the attempt started
+			t.mu.Unlock()
+			cancel()
+
+			continue
+		}
+		t.cancel = cancel
+		t.mu.Unlock()
+
+		ok = fetch(attemptCtx)
+		cancel()
+
+		t.mu.Lock()
+		t.cancel = nil
+		state = t.state
+		t.mu.Unlock()
+
+		if ok || ctx.Err() != nil || state != transferPaused {
+			return ok
+		}
+	}
+}
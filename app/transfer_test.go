@@ -0,0 +1,146 @@
+package app
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingFetch counts its attempts; each waits for ctx unless succeed is
+// set.
+func blockingFetch(attempts *atomic.Int32, succeed *atomic.Bool) func(context.Context) bool {
+	return func(ctx context.Context) bool {
+		attempts.Add(1)
+		if succeed.Load() {
+			return true
+		}
+
+		<-ctx.Done()
+
+		return false
+	}
+}
+
+func waitAttempts(t *testing.T, attempts *atomic.Int32, want int32) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for attempts.Load() < want && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if got := attempts.Load(); got != want {
+		t.Fatalf("attempts = %d, want %d", got, want)
+	}
+}
+
+func TestTransferPauseResume(t *testing.T) {
+	var (
+		attempts atomic.Int32
+		succeed  atomic.Bool
+	)
+
+	control := newTransferControl()
+	result := make(chan bool, 1)
+
+	go func() { result <- control.run(context.Background(), blockingFetch(&attempts, &succeed)) }()
+
+	waitAttempts(t, &attempts, 1)
+
+	control.togglePause()
+	if got := control.current(); got != transferPaused {
+		t.Fatalf("state after pause = %d, want paused", got)
+	}
+
+	// the paused transfer waits for a resume
+	time.Sleep(20 * time.Millisecond)
+	waitAttempts(t, &attempts, 1)
+
+	succeed.Store(true)
+	control.togglePause()
+
+	if ok := <-result; !ok {
+		t.Error("resumed transfer should complete")
+	}
+	if got := attempts.Load(); got != 2 {
+		t.Errorf("attempts = %d, want 2", got)
+	}
+
+	// the keys no longer apply to a finished transfer
+	control.pause()
+	if got := control.current(); got != transferDone {
+		t.Errorf("state after finish = %d, want done", got)
+	}
+}
+
+func TestTransferCancel(t *testing.T) {
+	var (
+		attempts atomic.Int32
+		succeed  atomic.Bool
+	)
+
+	for _, pauseFirst := range []bool{false, true} {
+		attempts.Store(0)
+
+		control := newTransferControl()
+		result := make(chan bool, 1)
+
+		go func() { result <- control.run(context.Background(), blockingFetch(&attempts, &succeed)) }()
+
+		waitAttempts(t, &attempts, 1)
+
+		if pauseFirst {
+			control.pause()
+		}
+		control.stop()
+
+		if ok := <-result; ok {
+			t.Errorf("pauseFirst=%v: canceled transfer should not complete", pauseFirst)
+		}
+		if got := control.current(); got != transferCanceled {
+			t.Errorf("pauseFirst=%v: state = %d, want canceled", pauseFirst, got)
+		}
+	}
+}
+
+func TestTransferKeys(t *testing.T) {
+	app := &CLIApplication{}
+	pd := newProgressDisplay()
+
+	var a, b atomic.Int64
+	ca, cb := newTransferControl(), newTransferControl()
+	pd.addTransfer("a.bin", &a, 10, ca)
+	pd.addTransfer("b.bin", &b, 10, cb)
+
+	// without a display the keys do nothing
+	app.transferKey('P')
+	if ca.current() != transferRunning {
+		t.Fatal("keys should not act without a progress display")
+	}
+
+	app.display.Store(pd)
+
+	app.transferKey('j')
+	app.transferKey('j')
+	app.transferKey('p')
+	if ca.current() != transferRunning || cb.current() != transferPaused {
+		t.Errorf("after j j p: a = %d, b = %d, want a running, b paused", ca.current(), cb.current())
+	}
+
+	app.transferKey('k')
+	app.transferKey('c')
+	if ca.current() != transferCanceled {
+		t.Errorf("after k c: a = %d, want canceled", ca.current())
+	}
+
+	app.transferKey('R')
+	if cb.current() != transferRunning {
+		t.Errorf("after R: b = %d, want running", cb.current())
+	}
+
+	app.transferKey('P')
+	if ca.current() != transferCanceled || cb.current() != transferPaused {
+		t.Errorf("after P: a = %d, b = %d, want a canceled, b paused", ca.current(), cb.current())
+	}
+}
@@ -7,12 +7,74 @@ usage:
 
   cat files.txt | %[1]s [-flags]
 
+  %[1]s [-flags] daemon
+  %[1]s add URL ... | list | pause ID | resume ID | remove ID
+
   flags:
 
-  -version        display version information (%s)
-  -verbose        verbose output / debug logging (default: false)
-  -chunks N       chunk size for parallel download (default: 5)
-  -limit RATE     bandwidth limit, e.g. 5M, 500K (default: 0, unlimited)
-  -output DIR     output directory (default: current directory)
+  -version              display version information (%s)
+  -verbose              verbose output / debug logging (default: false)
+  -chunks N             chunk size for parallel download (default: 5)
+  -jobs N               maximum files downloading at once, the rest are queued
+                        (default: 0, all at once)
+  -order POLICY         which queued file starts next: input, smallest, largest
+                        or priority, needs -jobs N (default: input)
+  -limit RATE           bandwidth limit, e.g. 5M, 500K (default: 0, unlimited)
+  -limit-schedule S     time of day limits, e.g. "08:00-18:00=1M,18:00-08:00=0";
+                        -limit applies outside the listed windows
+  -control PATH         unix socket for changing the limit at runtime
+  -state-dir DIR        queue and socket of leech daemon
+                        (default: $XDG_STATE_HOME/leech or ~/.local/state/leech)
+  -rpc-listen ADDR      daemon: serve aria2 compatible JSON-RPC on ADDR, e.g.
+                        localhost:6800; use -rpc-secret beyond loopback
+  -rpc-secret TOKEN     daemon: secret the JSON-RPC clients send as token:TOKEN
+  -watch DIR            download the url lists (.txt, .list, .urls) and feeds
+                        (.rss, .atom, .xml) dropped into DIR, then move each to
+                        DIR/done or DIR/failed
+  -watch-interval D     how often -watch looks for new lists (default: 5s)
+  -limit-per-file RATE  bandwidth limit of each download (default: 0, unlimited)
+  -limit-per-host RATE  bandwidth limit of each host (default: 0, unlimited)
+  -limit-burst SIZE     bytes a limit lets through at once after idling
+                        (default: 0, one second worth of the rate)
+  -output DIR           output directory (default: current directory)
+  -range SPAN           download only a byte range: START-END, START- or the
+                        last N bytes as -N; sizes take K/M/G, e.g. -1M
+  -O -                  write to stdout in order, still over parallel
+                        connections; progress and logs go to stderr
+  -stream-buffer SIZE   memory cap for reordering chunks with -O - (default: 64M)
+  -name TEMPLATE        output name for url patterns, #1 is replaced by the
+                        value of the first [] or {} glob, #2 the second, ...
+  -globoff              treat [] and {} in urls literally
+  -wait DURATION        time between requests to the same host, e.g. 2s;
+                        a host answering 429 is slowed down on its own
+  -random-wait          vary -wait between 0.5 and 1.5 times
+  -max-connections N    maximum open connections in total (default: 0, unlimited)
+  -max-conn-per-host N  maximum open connections per host (default: 0, unlimited)
+  -bind-address IP      local address for outgoing connections; a comma
+                        separated list spreads connections round-robin
+  -interface NAME       network interface for outgoing connections
+  -resolve H:P:ADDR     connect to ADDR for host H and port P, keeping the
+                        original Host header and TLS SNI (repeatable)
+  -4, -6                use IPv4 or IPv6 addresses only
+  -doh-url URL          resolve host names via DNS-over-HTTPS (RFC 8484)
+  -doh-fallback         use the system resolver when the DoH server fails,
+                        not for names it says do not exist
+  -s3-endpoint URL      s3 compatible endpoint, e.g. MinIO (default: AWS)
+  -s3-region REGION     s3 region (default: $AWS_REGION or us-east-1)
+  -s3-profile NAME      profile in ~/.aws/credentials (default: $AWS_PROFILE)
+  -recursive            crawl HTML pages and download the linked files,
+                        keeping the directory structure
+  -depth N              maximum crawl depth (default: 5)
+  -accept GLOBS         download only files matching, e.g. "*.iso,*.sha256"
+  -reject GLOBS         skip files matching, e.g. "*.tmp"
+  -span-hosts           follow links to other hosts (default: same host only)
+  -allow-parent         follow links above the start directory
+  -ignore-robots        do not honor robots.txt
+  -feed URL             download the enclosures of an RSS/Atom feed, named
+                        "YYYY-MM-DD Title.ext" (repeatable)
+  -feed-newest N        only the newest N enclosures of each feed (default: all)
+  -feed-new             skip enclosures already in the output directory
+  -hls-variant V        hls variant for .m3u8 URLs: best, worst, WxH, 720p or
+                        max bandwidth in bits/s (default: best)
 
 `
@@ -0,0 +1,277 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	neturl "net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultWatchInterval = 5 * time.Second
+	watchDoneDir         = "done"
+	watchFailedDir       = "failed"
+)
+
+// watchFormats are the list files picked up from the watched directory:
+// URL lists as piped in, one URL or pattern per line with options, and
+// RSS/Atom feeds whose enclosures are downloaded.
+var watchFormats = map[string]bool{
+	".txt":  false,
+	".list": false,
+	".urls": false,
+	".rss":  true,
+	".atom": true,
+	".xml":  true,
+}
+
+// watchStamp is what a list file looked like on the last poll. A file is
+// taken once it stops changing, so one still being written is left alone.
+type watchStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// watcher polls a directory for list files, downloads their URLs and
+// moves each list to done/ or failed/ once it is processed.
+type watcher struct {
+	app  *CLIApplication
+	seen map[string]watchStamp
+	dir  string
+}
+
+func newWatcher(c *CLIApplication, dir string) *watcher {
+	return &watcher{app: c, dir: dir, seen: make(map[string]watchStamp)}
+}
+
+// runWatch runs watch mode until interrupted. The URLs come from the
+// watched directory only.
+func (c *CLIApplication) runWatch(args []string) error {
+	if len(args) > 0 || c.crawlOpts != nil || c.feedOpts != nil || c.toStdout {
+		return errors.New("-watch takes its urls from the list files only, not with urls, -recursive, -feed or -O")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if c.schedule != nil {
+		go c.runSchedule(ctx)
+	}
+
+	stopControl, err := c.startControl(ctx)
+	if err != nil {
+		return err
+	}
+	defer stopControl()
+
+	w := newWatcher(c, c.watchDir)
+
+	for _, dir := range []string{c.outputDir, w.dir, filepath.Join(w.dir, watchDoneDir), filepath.Join(w.dir, watchFailedDir)} {
+		if err := os.MkdirAll(dir, permDir); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+
+	slog.Info("watching", "dir", w.dir, "interval", c.watchEvery, "output", c.outputDir)
+
+	ticker := time.NewTicker(c.watchEvery)
+	defer ticker.Stop()
+
+	for {
+		w.poll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll processes the list files that did not change since the last poll,
+// oldest first.
+func (w *watcher) poll(ctx context.Context) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		slog.Error("failed to read watch directory", "dir", w.dir, logKeyError, err)
+
+		return
+	}
+
+	type ready struct {
+		modTime time.Time
+		name    string
+	}
+
+	var files []ready
+
+	current := make(map[string]watchStamp)
+
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		if _, ok := watchFormats[strings.ToLower(filepath.Ext(name))]; !ok {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+
+		stamp := watchStamp{modTime: info.ModTime(), size: info.Size()}
+		current[name] = stamp
+
+		if prev, ok := w.seen[name]; ok && prev == stamp {
+			files = append(files, ready{modTime: stamp.modTime, name: name})
+		}
+	}
+
+	w.seen = current
+
+	slices.SortFunc(files, func(a, b ready) int { return a.modTime.Compare(b.modTime) })
+
+	for _, f := range files {
+		if ctx.Err() != nil {
+			return
+		}
+
+		w.process(ctx, f.name)
+		delete(w.seen, f.name)
+	}
+}
+
+// process downloads the URLs of one list file and moves it to done/ or,
+// if anything in it failed, to failed/. A list cut short by an interrupt
+// stays, the next run continues its part files.
+func (w *watcher) process(ctx context.Context, name string) {
+	path := filepath.Join(w.dir, name)
+
+	slog.Info("processing list", logKeyFile, name)
+
+	err := w.download(ctx, path)
+	if ctx.Err() != nil {
+		return
+	}
+
+	target := watchDoneDir
+	if err != nil {
+		slog.Error("list failed", logKeyFile, name, logKeyError, err)
+		target = watchFailedDir
+	} else {
+		slog.Info("list done", logKeyFile, name)
+	}
+
+	moved, err := moveUnique(path, filepath.Join(w.dir, target))
+	if err != nil {
+		slog.Error("failed to move list", logKeyFile, name, logKeyError, err)
+
+		return
+	}
+
+	slog.Debug("list moved", logKeyFile, moved)
+}
+
+// download runs the URLs of the list at path through the same pipeline as
+// a normal run. It fails if any URL could not be probed or downloaded.
+func (w *watcher) download(ctx context.Context, path string) error {
+	c := w.app
+
+	urls, err := c.listURLs(path)
+	if err != nil {
+		return err
+	}
+	c.URLS = urls
+
+	queue := newJobQueue(c.jobs, c.order)
+	stats := &pipelineStats{}
+	resources := c.probeURLs(ctx, c.sourceURLs(ctx, nil, queue, stats), queue, stats)
+
+	if err := c.runDownloads(ctx, resources, queue, stats); err != nil {
+		return err
+	}
+
+	total := stats.urls.Load()
+	if failed := total - stats.probed.Load(); failed > 0 {
+		return fmt.Errorf("%d of %d url(s) failed", failed, total)
+	}
+
+	return nil
+}
+
+// listURLs reads the URLs of a list file, by its extension a URL list or a
+// feed.
+func (c *CLIApplication) listURLs(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read list: %w", err)
+	}
+
+	var urls []string
+
+	if watchFormats[strings.ToLower(filepath.Ext(path))] {
+		// a feed file has no URL of its own, relative enclosures resolve
+		// against the links the feed carries
+		enclosures, err := parseFeed(data, &neturl.URL{})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, enc := range enclosures {
+			c.setHint(enc.url, urlHint{filename: enc.filename()})
+			urls = append(urls, enc.url)
+		}
+	} else {
+		err = scanURLs(bytes.NewReader(data), func(line string) bool {
+			return c.addLine(line, func(url string) bool {
+				urls = append(urls, url)
+
+				return true
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(urls) == 0 {
+		return nil, errEmptyURL
+	}
+
+	return urls, nil
+}
+
+// moveUnique moves path into dir, as name_1.ext, name_2.ext, ... if a file
+// of that name is there already, and returns the new path.
+func moveUnique(path, dir string) (string, error) {
+	name := filepath.Base(path)
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	target := filepath.Join(dir, name)
+
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(target); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+
+		target = filepath.Join(dir, fmt.Sprintf("%s_%d%s", base, i, ext))
+	}
+
+	if err := os.Rename(path, target); err != nil {
+		return "", fmt.Errorf("failed to move %s: %w", path, err)
+	}
+
+	return target, nil
+}
@@ -0,0 +1,143 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestListURLs(t *testing.T) {
+	dir := t.TempDir()
+	app := &CLIApplication{}
+
+	list := filepath.Join(dir, "batch.txt")
+	if err := os.WriteFile(list, []byte("https://example.com/a.iso\r\n\nhttps://example.com/b[1-2].iso priority=5\n"), permFile); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := app.listURLs(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"https://example.com/a.iso", "https://example.com/b1.iso", "https://example.com/b2.iso"}
+	if !slices.Equal(got, want) {
+		t.Errorf("listURLs(txt) = %v, want %v", got, want)
+	}
+	if app.hint("https://example.com/b2.iso").priority != 5 {
+		t.Error("priority= should be kept for the listed urls")
+	}
+
+	feed := filepath.Join(dir, "podcast.rss")
+	rss := `<rss><channel><item><title>Episode 1</title><pubDate>Mon, 02 Jan 2006 15:04:05 -0700</pubDate>
+<enclosure url="https://example.com/ep1.mp3" type="audio/mpeg"/></item></channel></rss>`
+	if err := os.WriteFile(feed, []byte(rss), permFile); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = app.listURLs(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, []string{"https://example.com/ep1.mp3"}) {
+		t.Errorf("listURLs(rss) = %v", got)
+	}
+	if name := app.hint("https://example.com/ep1.mp3").filename; name != "2006-01-02 Episode 1.mp3" {
+		t.Errorf("enclosure name = %q", name)
+	}
+
+	empty := filepath.Join(dir, "empty.txt")
+	if err := os.WriteFile(empty, []byte("\n\n"), permFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.listURLs(empty); err == nil {
+		t.Error("an empty list should fail")
+	}
+}
+
+func TestWatcherPoll(t *testing.T) {
+	content := []byte("abcdefghijklmnopqrstuvwxyz0123456789")
+	ts := newTestServer(content, true)
+	defer ts.Close()
+
+	dir := t.TempDir()
+	for _, sub := range []string{watchDoneDir, watchFailedDir} {
+		if err := os.Mkdir(filepath.Join(dir, sub), permDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app := &CLIApplication{
+		Client:    ts.Client(),
+		chunkSize: 2,
+		limiter:   newRateLimiter(0),
+		outputDir: t.TempDir(),
+	}
+
+	files := map[string]string{
+		"good.txt":    ts.URL + "/alphabet.bin\n",
+		"bad.txt":     ts.URL + "/alphabet.bin\nftp://127.0.0.1:1/missing.bin\n",
+		"notes.md":    ts.URL + "/ignored.bin\n",
+		".hidden.txt": ts.URL + "/ignored.bin\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), permFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := newWatcher(app, dir)
+
+	// the first poll only notes the files, they may still be written
+	w.poll(context.Background())
+	if _, err := os.Stat(filepath.Join(dir, "good.txt")); err != nil {
+		t.Fatal("a list should not be taken on the poll that first sees it")
+	}
+
+	w.poll(context.Background())
+
+	for path, want := range map[string]bool{
+		filepath.Join(dir, watchDoneDir, "good.txt"):  true,
+		filepath.Join(dir, watchFailedDir, "bad.txt"): true,
+		filepath.Join(dir, "good.txt"):                false,
+		filepath.Join(dir, "notes.md"):                true,
+		filepath.Join(dir, ".hidden.txt"):             true,
+	} {
+		if _, err := os.Stat(path); (err == nil) != want {
+			t.Errorf("%s exists = %v, want %v", path, err == nil, want)
+		}
+	}
+
+	got, err := os.ReadFile(filepath.Join(app.outputDir, "alphabet.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(content) {
+		t.Errorf("content = %q, want %q", got, content)
+	}
+}
+
+func TestMoveUnique(t *testing.T) {
+	dir := t.TempDir()
+	done := filepath.Join(dir, watchDoneDir)
+	if err := os.Mkdir(done, permDir); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"list.txt", "list_1.txt", "list_2.txt"} {
+		src := filepath.Join(dir, "list.txt")
+		if err := os.WriteFile(src, nil, permFile); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := moveUnique(src, done)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != filepath.Join(done, want) {
+			t.Errorf("moveUnique = %s, want %s", got, want)
+		}
+	}
+}